@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vbauerster/mpb/v7"
+	"github.com/vbauerster/mpb/v7/decor"
+	"ryan/paralload/paralload"
+)
+
+// batchResult is a row of the summary that is printed after a batch download
+type batchResult struct {
+	url      string
+	path     string
+	size     int64
+	duration time.Duration
+	skipped  bool
+	err      error
+}
+
+// BatchProgress shows a single bar for a file of a batch download, the bars
+// of all files share one progress container
+type BatchProgress struct {
+	progressContainer *mpb.Progress
+	name              string
+	progressBar       *mpb.Bar
+	counter           *byteCounter
+	size              int64
+	mutex             sync.Mutex
+}
+
+func newBatchProgress(progressContainer *mpb.Progress, name string) *BatchProgress {
+	return &BatchProgress{
+		progressContainer: progressContainer,
+		name:              name,
+		counter:           newByteCounter(),
+	}
+}
+
+func (batchProgress *BatchProgress) Started(info *paralload.Info, chunkCount int64, completedChunks int64) {
+	batchProgress.mutex.Lock()
+	defer batchProgress.mutex.Unlock()
+	batchProgress.size = info.ContentLength
+	batchProgress.counter.started(info, chunkCount, completedChunks)
+	total := info.ContentLength
+	if total < 0 {
+		total = 0
+	}
+	batchProgress.progressBar = batchProgress.progressContainer.New(
+		total,
+		mpb.BarStyle().Padding(" "),
+		mpb.PrependDecorators(
+			decor.Name(batchProgress.name, decor.WC{W: len(batchProgress.name) + 1, C: decor.DidentRight}),
+		),
+		mpb.AppendDecorators(
+			decor.Any(func(decor.Statistics) string {
+				return batchProgress.counter.stats().String()
+			}),
+		),
+	)
+	current, _ := batchProgress.counter.progress()
+	batchProgress.progressBar.SetCurrent(current)
+}
+
+func (batchProgress *BatchProgress) setCurrent(current int64) {
+	batchProgress.mutex.Lock()
+	defer batchProgress.mutex.Unlock()
+	batchProgress.progressBar.SetCurrent(current)
+}
+
+func (batchProgress *BatchProgress) ChunkStarted(chunk *paralload.Chunk) {
+	batchProgress.counter.chunkStarted()
+}
+
+func (batchProgress *BatchProgress) ChunkProgress(chunk *paralload.Chunk, downloaded int64) {
+	batchProgress.setCurrent(batchProgress.counter.set(chunk, downloaded))
+}
+
+func (batchProgress *BatchProgress) ChunkCompleted(chunk *paralload.Chunk) {
+	batchProgress.setCurrent(batchProgress.counter.chunkCompleted(chunk))
+}
+
+func (batchProgress *BatchProgress) ChunkFailed(chunk *paralload.Chunk, err error) {}
+
+func (batchProgress *BatchProgress) Verifying(checksum *paralload.Checksum) {}
+
+func (batchProgress *BatchProgress) DiskFull(err *paralload.DiskSpaceError) {
+	fmt.Printf("The disk is full, %v is paused until %v bytes are free (%v bytes are available).\n", batchProgress.name, err.Needed, err.Available)
+}
+
+// finish completes the bar of a successful download and removes the bar of a failed one
+func (batchProgress *BatchProgress) finish(succeeded bool) {
+	batchProgress.mutex.Lock()
+	defer batchProgress.mutex.Unlock()
+	if batchProgress.progressBar == nil {
+		return
+	}
+	if succeeded {
+		batchProgress.progressBar.SetTotal(-1, true)
+	} else {
+		batchProgress.progressBar.Abort(true)
+	}
+}
+
+func startCliBatchDownloads(ctx context.Context, inputPath string, directory string, existsPolicy paralload.ExistsPolicy, concurrency int, options paralload.Options) int {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		fmt.Println("Unable to read the input file: " + err.Error())
+		return 1
+	}
+	entries, err := paralload.ParseInputFile(inputFile)
+	inputFile.Close()
+	if err != nil {
+		fmt.Println("Unable to read the input file: " + err.Error())
+		return 1
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	// every file may use all workers on its own, but together they share them
+	workerBudget := paralload.NewDownloader(options, nil).Options().Workers
+	options.WorkerBudget = paralload.NewWorkerBudget(workerBudget)
+	fmt.Printf("Downloading %v files, %v at a time with up to %v workers...\n", len(entries), concurrency, workerBudget)
+
+	progressContainer := mpb.New()
+	claimedPaths := &claimedPaths{paths: make(map[string]bool)}
+	results := make([]batchResult, len(entries))
+	indices := make(chan int)
+	var waitGroup sync.WaitGroup
+	for file := 0; file < concurrency; file++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for index := range indices {
+				results[index] = downloadBatchEntry(ctx, entries[index], directory, existsPolicy, options, progressContainer, claimedPaths)
+			}
+		}()
+	}
+	for index := range entries {
+		indices <- index
+	}
+	close(indices)
+	waitGroup.Wait()
+	progressContainer.Wait()
+
+	return printBatchSummary(results)
+}
+
+// claimedPaths keeps files of the same batch from being saved to the same path
+type claimedPaths struct {
+	paths map[string]bool
+	mutex sync.Mutex
+}
+
+func (claimedPaths *claimedPaths) claim(path string) bool {
+	claimedPaths.mutex.Lock()
+	defer claimedPaths.mutex.Unlock()
+	if claimedPaths.paths[path] {
+		return false
+	}
+	claimedPaths.paths[path] = true
+	return true
+}
+
+// claimUnique claims path, or if another file of the batch has claimed it or
+// a file exists there, the first path of the form "name (1).ext" that is
+// neither claimed nor used by a file or the part file of another download
+func (claimedPaths *claimedPaths) claimUnique(path string) string {
+	claimedPaths.mutex.Lock()
+	defer claimedPaths.mutex.Unlock()
+	if _, err := os.Lstat(path); os.IsNotExist(err) && !claimedPaths.paths[path] {
+		claimedPaths.paths[path] = true
+		return path
+	}
+	path = paralload.UniquePathFunc(path, func(candidate string) bool {
+		if claimedPaths.paths[candidate] {
+			return true
+		}
+		if _, err := os.Lstat(candidate); !os.IsNotExist(err) {
+			return true
+		}
+		_, err := os.Lstat(paralload.PartPath(candidate))
+		return !os.IsNotExist(err)
+	})
+	claimedPaths.paths[path] = true
+	return path
+}
+
+func downloadBatchEntry(ctx context.Context, entry *paralload.InputFileEntry, directory string, existsPolicy paralload.ExistsPolicy, options paralload.Options, progressContainer *mpb.Progress, claimedPaths *claimedPaths) batchResult {
+	url := entry.Apply(&options)
+	result := batchResult{url: url}
+	if result.err = ctx.Err(); result.err != nil {
+		return result
+	}
+	if filepath.IsAbs(entry.Dir) {
+		directory = entry.Dir
+	} else {
+		directory = filepath.Join(directory, entry.Dir)
+	}
+	if result.err = os.MkdirAll(directory, 0755); result.err != nil {
+		return result
+	}
+	path := filepath.Join(directory, entry.FileName())
+	if entry.FileName() == "" {
+		path, result.err = outputPath(ctx, url, directory, options)
+		if result.err != nil {
+			return result
+		}
+	}
+	result.path = path
+	// the path is claimed before the existing file is touched, renaming
+	// resolves and claims it at once so that two files of the batch with
+	// the same name get different paths
+	if existsPolicy == paralload.ExistsRename {
+		path = claimedPaths.claimUnique(path)
+	} else {
+		if !claimedPaths.claim(path) {
+			result.err = errors.New("another file of the input file is saved to the same path, set a name with out=")
+			return result
+		}
+		path, result.err = paralload.NewDownloader(options, nil).ResolveExisting(ctx, url, path, existsPolicy)
+		if result.err == paralload.ErrUpToDate {
+			result.skipped = true
+			result.err = nil
+			return result
+		} else if result.err != nil {
+			return result
+		}
+	}
+	result.path = path
+	options.StatePath = paralload.StatePath(path)
+	options.Continue = existsPolicy == paralload.ExistsResume
+
+	batchProgress := newBatchProgress(progressContainer, filepath.Base(path))
+	start := time.Now()
+	result.err = paralload.NewDownloader(options, batchProgress).DownloadFile(ctx, url, path)
+	result.duration = time.Since(start)
+	result.size = batchProgress.size
+	batchProgress.finish(result.err == nil)
+	return result
+}
+
+// printBatchSummary prints a table of the results and returns the exit code
+func printBatchSummary(results []batchResult) int {
+	exitCode := 0
+	succeeded, skipped, failed := 0, 0, 0
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "STATUS\tSIZE\tTIME\tFILE\tERROR")
+	for _, result := range results {
+		status := "done"
+		if result.err != nil {
+			status = "failed"
+			exitCode = 1
+			failed++
+		} else if result.skipped {
+			status = "skipped"
+			skipped++
+		} else {
+			succeeded++
+		}
+		file := result.path
+		if file == "" {
+			file = result.url
+		}
+		size := "-"
+		if result.size >= 0 && !result.skipped && result.err == nil {
+			size = fmt.Sprintf("%.1f MiB", float64(result.size)/(1<<20))
+		}
+		errorText := ""
+		if result.err != nil {
+			errorText = result.err.Error()
+		}
+		fmt.Fprintf(table, "%v\t%v\t%v\t%v\t%v\n", status, size, result.duration.Round(time.Second/10), file, errorText)
+	}
+	table.Flush()
+	fmt.Printf("%v downloaded, %v skipped, %v failed\n", succeeded, skipped, failed)
+	return exitCode
+}
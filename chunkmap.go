@@ -0,0 +1,376 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"sort"
+	"strings"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"ryan/paralload/paralload"
+)
+
+const chunkMapHeight float32 = 16
+
+// chunkSegment is a range of the file that is being downloaded by a worker,
+// its fields are copied from the chunk so that it can be drawn at any time
+type chunkSegment struct {
+	label      string
+	offset     int64
+	length     int64
+	downloaded int64
+	mirror     string
+	retries    int
+	failed     bool
+	duplicate  bool
+}
+
+// chunkDetails describes a chunk for hovering it once it is complete, its
+// parts may have been downloaded by several workers from several mirrors
+type chunkDetails struct {
+	label   string
+	offset  int64
+	length  int64
+	mirrors []string
+	retries int
+	// resumed is true if the chunk was completed before the download was resumed
+	resumed bool
+}
+
+type byteRange struct {
+	start, end int64
+}
+
+// ChunkMap draws the whole file as a bar, like the piece map of a torrent
+// client. Pending ranges are gray, ranges that are being downloaded blue,
+// downloaded ranges green and ranges that are being retried red. Hovering
+// a range shows the worker that downloads it, its mirror and its retries,
+// which are kept by chunk id once the chunk is complete.
+type ChunkMap struct {
+	widget.BaseWidget
+	size       int64
+	chunkCount int64
+	maxRetries int
+	// completed are the downloaded ranges of the completed chunks, sorted and merged
+	completed []byteRange
+	segments  map[*paralload.Chunk]*chunkSegment
+	chunks    map[int]*chunkDetails
+	// hoverX is the position of the mouse, or -1 if it isn't over the map
+	hoverX float32
+	mutex  sync.Mutex
+}
+
+func NewChunkMap(maxRetries int) *ChunkMap {
+	chunkMap := &ChunkMap{
+		maxRetries: maxRetries,
+		segments:   make(map[*paralload.Chunk]*chunkSegment),
+		chunks:     make(map[int]*chunkDetails),
+		hoverX:     -1,
+	}
+	chunkMap.ExtendBaseWidget(chunkMap)
+	return chunkMap
+}
+
+// reset clears the map for a download of size bytes in chunkCount chunks, a
+// size of -1 (a stream of an unknown size) is drawn as a single range
+func (chunkMap *ChunkMap) reset(size int64, chunkCount int64) {
+	chunkMap.mutex.Lock()
+	defer chunkMap.mutex.Unlock()
+	chunkMap.size = size
+	chunkMap.chunkCount = chunkCount
+	chunkMap.completed = nil
+	chunkMap.segments = make(map[*paralload.Chunk]*chunkSegment)
+	chunkMap.chunks = make(map[int]*chunkDetails)
+}
+
+func (chunkMap *ChunkMap) chunkStarted(chunk *paralload.Chunk, label string) {
+	chunkMap.mutex.Lock()
+	defer chunkMap.mutex.Unlock()
+	chunkMap.segments[chunk] = &chunkSegment{
+		label:     label,
+		offset:    chunk.Offset,
+		length:    chunk.Length,
+		mirror:    chunk.Mirror,
+		duplicate: chunk.Duplicate,
+	}
+	// the original range of the chunk covers the parts split off it later
+	if chunk.Split == 0 && !chunk.Duplicate {
+		chunkMap.chunks[chunk.Id] = &chunkDetails{label: label, offset: chunk.Offset, length: chunk.Length}
+	}
+}
+
+func (chunkMap *ChunkMap) chunkProgress(chunk *paralload.Chunk, downloaded int64) {
+	chunkMap.mutex.Lock()
+	defer chunkMap.mutex.Unlock()
+	if segment := chunkMap.segments[chunk]; segment != nil {
+		segment.downloaded = downloaded
+		segment.mirror = chunk.Mirror
+		segment.failed = false
+	}
+}
+
+func (chunkMap *ChunkMap) chunkSplit(chunk *paralload.Chunk) {
+	chunkMap.mutex.Lock()
+	defer chunkMap.mutex.Unlock()
+	if segment := chunkMap.segments[chunk]; segment != nil {
+		segment.length = chunk.Length
+	}
+}
+
+func (chunkMap *ChunkMap) chunkFailed(chunk *paralload.Chunk) {
+	chunkMap.mutex.Lock()
+	defer chunkMap.mutex.Unlock()
+	if segment := chunkMap.segments[chunk]; segment != nil {
+		segment.retries = chunk.Retries
+		segment.mirror = chunk.Mirror
+		segment.failed = true
+	}
+}
+
+// chunkCompleted marks the range of the chunk as downloaded, the ranges of
+// endgame duplicates are covered by the chunk they raced
+func (chunkMap *ChunkMap) chunkCompleted(chunk *paralload.Chunk) {
+	chunkMap.mutex.Lock()
+	defer chunkMap.mutex.Unlock()
+	segment := chunkMap.segments[chunk]
+	delete(chunkMap.segments, chunk)
+	if segment == nil {
+		return
+	}
+	if details := chunkMap.chunks[chunk.Id]; details != nil {
+		details.retries += segment.retries
+		if segment.mirror != "" && !containsString(details.mirrors, segment.mirror) {
+			details.mirrors = append(details.mirrors, segment.mirror)
+		}
+	}
+	if segment.duplicate {
+		return
+	}
+	length := segment.length
+	if length < 0 {
+		length = segment.downloaded
+	}
+	chunkMap.addCompleted(byteRange{segment.offset, segment.offset + length})
+}
+
+func (chunkMap *ChunkMap) chunksResumed(chunks []*paralload.Chunk) {
+	chunkMap.mutex.Lock()
+	defer chunkMap.mutex.Unlock()
+	for _, chunk := range chunks {
+		chunkMap.addCompleted(byteRange{chunk.Offset, chunk.Offset + chunk.Length})
+		chunkMap.chunks[chunk.Id] = &chunkDetails{
+			label:   chunkLabel(chunk, chunkMap.chunkCount),
+			offset:  chunk.Offset,
+			length:  chunk.Length,
+			resumed: true,
+		}
+	}
+}
+
+// addCompleted adds a range to the completed ranges and merges it with its
+// neighbours, the mutex has to be held
+func (chunkMap *ChunkMap) addCompleted(completed byteRange) {
+	ranges := append(chunkMap.completed, completed)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+	merged := ranges[:1]
+	for _, current := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if current.start <= last.end {
+			if current.end > last.end {
+				last.end = current.end
+			}
+		} else {
+			merged = append(merged, current)
+		}
+	}
+	chunkMap.completed = merged
+}
+
+// describe returns the text below the map, which describes the range at the
+// mouse position or all chunks if the mouse isn't over the map
+func (chunkMap *ChunkMap) describe(width float32) string {
+	chunkMap.mutex.Lock()
+	defer chunkMap.mutex.Unlock()
+	if chunkMap.hoverX < 0 || chunkMap.size <= 0 || width <= 0 {
+		inFlight := 0
+		for _, segment := range chunkMap.segments {
+			if !segment.duplicate {
+				inFlight++
+			}
+		}
+		return fmt.Sprintf("%v chunks, %v being downloaded (hover over the map for details)", chunkMap.chunkCount, inFlight)
+	}
+	offset := int64(float64(chunkMap.hoverX) / float64(width) * float64(chunkMap.size))
+	// prefer the original range over the duplicates that race it
+	var hovered *chunkSegment
+	for _, segment := range chunkMap.segments {
+		if offset >= segment.offset && offset < segment.offset+segment.length && (hovered == nil || hovered.duplicate) {
+			hovered = segment
+		}
+	}
+	if hovered != nil {
+		state := "downloading"
+		if hovered.failed {
+			state = "retrying"
+		}
+		return fmt.Sprintf("%v: %v from %v (%v/%v retries)", hovered.label, state, hovered.mirror, hovered.retries, chunkMap.maxRetries)
+	}
+	for _, completed := range chunkMap.completed {
+		if offset < completed.start || offset >= completed.end {
+			continue
+		}
+		for _, details := range chunkMap.chunks {
+			if offset < details.offset || (details.length >= 0 && offset >= details.offset+details.length) {
+				continue
+			}
+			if details.resumed {
+				return fmt.Sprintf("%v: completed before the download was resumed", details.label)
+			}
+			return fmt.Sprintf("%v: completed from %v (%v/%v retries)", details.label, strings.Join(details.mirrors, ", "), details.retries, chunkMap.maxRetries)
+		}
+		return fmt.Sprintf("Bytes %v-%v: completed", completed.start, completed.end-1)
+	}
+	return fmt.Sprintf("Byte %v: pending", offset)
+}
+
+func containsString(values []string, value string) bool {
+	for _, current := range values {
+		if current == value {
+			return true
+		}
+	}
+	return false
+}
+
+// spans returns the colored ranges of the map from left to right, the
+// pending background is not included
+func (chunkMap *ChunkMap) spans() ([]byteRange, []color.Color) {
+	chunkMap.mutex.Lock()
+	defer chunkMap.mutex.Unlock()
+	var ranges []byteRange
+	var colors []color.Color
+	for _, completed := range chunkMap.completed {
+		ranges = append(ranges, completed)
+		colors = append(colors, theme.SuccessColor())
+	}
+	for _, segment := range chunkMap.segments {
+		length := segment.length
+		if length < 0 {
+			length = chunkMap.size
+		}
+		inFlight := theme.PrimaryColor()
+		if segment.failed {
+			inFlight = theme.ErrorColor()
+		}
+		ranges = append(ranges, byteRange{segment.offset, segment.offset + length})
+		colors = append(colors, inFlight)
+		if segment.downloaded > 0 && !segment.duplicate {
+			ranges = append(ranges, byteRange{segment.offset, segment.offset + segment.downloaded})
+			colors = append(colors, theme.SuccessColor())
+		}
+	}
+	return ranges, colors
+}
+
+func (chunkMap *ChunkMap) MouseIn(event *desktop.MouseEvent) {
+	chunkMap.MouseMoved(event)
+}
+
+func (chunkMap *ChunkMap) MouseMoved(event *desktop.MouseEvent) {
+	chunkMap.mutex.Lock()
+	chunkMap.hoverX = event.Position.X
+	chunkMap.mutex.Unlock()
+	chunkMap.Refresh()
+}
+
+func (chunkMap *ChunkMap) MouseOut() {
+	chunkMap.mutex.Lock()
+	chunkMap.hoverX = -1
+	chunkMap.mutex.Unlock()
+	chunkMap.Refresh()
+}
+
+func (chunkMap *ChunkMap) CreateRenderer() fyne.WidgetRenderer {
+	renderer := &chunkMapRenderer{
+		chunkMap:   chunkMap,
+		background: canvas.NewRectangle(theme.DisabledButtonColor()),
+		text:       canvas.NewText("", theme.ForegroundColor()),
+	}
+	renderer.text.TextSize = theme.CaptionTextSize()
+	renderer.Refresh()
+	return renderer
+}
+
+type chunkMapRenderer struct {
+	chunkMap   *ChunkMap
+	background *canvas.Rectangle
+	rectangles []*canvas.Rectangle
+	text       *canvas.Text
+	size       fyne.Size
+}
+
+func (renderer *chunkMapRenderer) Layout(size fyne.Size) {
+	renderer.size = size
+	renderer.background.Resize(fyne.NewSize(size.Width, chunkMapHeight))
+	renderer.text.Move(fyne.NewPos(0, chunkMapHeight+theme.Padding()))
+	renderer.text.Resize(fyne.NewSize(size.Width, renderer.text.MinSize().Height))
+	renderer.layoutSpans()
+}
+
+// layoutSpans places a rectangle on every colored range, ranges that are
+// narrower than a pixel are still drawn a pixel wide
+func (renderer *chunkMapRenderer) layoutSpans() {
+	ranges, colors := renderer.chunkMap.spans()
+	for len(renderer.rectangles) < len(ranges) {
+		renderer.rectangles = append(renderer.rectangles, canvas.NewRectangle(color.Transparent))
+	}
+	renderer.rectangles = renderer.rectangles[:len(ranges)]
+	size := renderer.chunkMap.size
+	for index, span := range ranges {
+		rectangle := renderer.rectangles[index]
+		rectangle.FillColor = colors[index]
+		if size <= 0 {
+			rectangle.Move(fyne.NewPos(0, 0))
+			rectangle.Resize(fyne.NewSize(renderer.size.Width, chunkMapHeight))
+			continue
+		}
+		start := float32(float64(span.start) / float64(size) * float64(renderer.size.Width))
+		width := float32(float64(span.end-span.start) / float64(size) * float64(renderer.size.Width))
+		if width < 1 {
+			width = 1
+		}
+		rectangle.Move(fyne.NewPos(start, 0))
+		rectangle.Resize(fyne.NewSize(width, chunkMapHeight))
+	}
+}
+
+func (renderer *chunkMapRenderer) MinSize() fyne.Size {
+	return fyne.NewSize(100, chunkMapHeight+theme.Padding()+renderer.text.MinSize().Height)
+}
+
+func (renderer *chunkMapRenderer) Refresh() {
+	renderer.background.FillColor = theme.DisabledButtonColor()
+	renderer.text.Color = theme.ForegroundColor()
+	renderer.text.Text = renderer.chunkMap.describe(renderer.size.Width)
+	renderer.layoutSpans()
+	renderer.background.Refresh()
+	for _, rectangle := range renderer.rectangles {
+		rectangle.Refresh()
+	}
+	renderer.text.Refresh()
+}
+
+func (renderer *chunkMapRenderer) Objects() []fyne.CanvasObject {
+	objects := []fyne.CanvasObject{renderer.background}
+	for _, rectangle := range renderer.rectangles {
+		objects = append(objects, rectangle)
+	}
+	return append(objects, renderer.text)
+}
+
+func (renderer *chunkMapRenderer) Destroy() {}
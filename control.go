@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"ryan/paralload/paralload"
+)
+
+// startControlSocket listens on a unix socket for commands that change the
+// running download, e.g. `echo "limit-rate 2M" | nc -U paralload.sock`
+func startControlSocket(ctx context.Context, path string) error {
+	// only replace leftovers of a previous run, never a regular file
+	if fileInfo, err := os.Lstat(path); err == nil && fileInfo.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleControlConnection(connection)
+		}
+	}()
+	return nil
+}
+
+func handleControlConnection(connection net.Conn) {
+	defer connection.Close()
+	scanner := bufio.NewScanner(connection)
+	for scanner.Scan() {
+		command, argument, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		switch command {
+		case "":
+		case "limit-rate":
+			rate, err := paralload.ParseRate(argument)
+			if err != nil {
+				fmt.Fprintf(connection, "error: %v\n", err)
+				continue
+			}
+			rateLimiter.SetLimit(rate)
+			fmt.Fprintf(connection, "ok: the rate limit is now %v\n", paralload.FormatRate(rate))
+		default:
+			fmt.Fprintf(connection, "error: unknown command %q (available commands: limit-rate <rate>)\n", command)
+		}
+	}
+}
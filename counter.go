@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"ryan/paralload/paralload"
+)
+
+// speedWindow is how far back the current speed of a download is measured
+const speedWindow = 3 * time.Second
+
+type speedSample struct {
+	time  time.Time
+	bytes int64
+}
+
+// byteCounter adds up the bytes a download has written so far. Endgame
+// duplicates are left out since they download bytes that are already
+// counted for the chunk they race.
+type byteCounter struct {
+	// downloaded are the bytes of the chunks that are being downloaded,
+	// completed chunks are only counted in written
+	downloaded map[*paralload.Chunk]int64
+	written    int64
+	resumed    int64
+	total      int64
+	// connections counts the chunks (including duplicates) that are being downloaded
+	connections int
+	startTime   time.Time
+	samples     []speedSample
+	mutex       sync.Mutex
+}
+
+// transferStats is a snapshot of a download for the total progress
+type transferStats struct {
+	current int64
+	// total is -1 if the size of the file is unknown
+	total int64
+	// speed is measured over the last speedWindow, averageSpeed since the
+	// download started (without the bytes of a resumed download)
+	speed        float64
+	averageSpeed float64
+	connections  int
+}
+
+func newByteCounter() *byteCounter {
+	return &byteCounter{downloaded: make(map[*paralload.Chunk]int64), total: -1}
+}
+
+// started estimates the bytes of the chunks that were completed before the
+// download was resumed, they all have the same length except for the last one
+func (counter *byteCounter) started(info *paralload.Info, chunkCount int64, completedChunks int64) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	counter.downloaded = make(map[*paralload.Chunk]int64)
+	counter.total = info.ContentLength
+	counter.resumed = 0
+	if info.ContentLength > 0 && chunkCount > 0 {
+		counter.resumed = info.ContentLength * completedChunks / chunkCount
+	}
+	counter.written = counter.resumed
+	counter.connections = 0
+	counter.startTime = time.Now()
+	counter.samples = nil
+}
+
+func (counter *byteCounter) chunkStarted() {
+	counter.mutex.Lock()
+	counter.connections++
+	counter.mutex.Unlock()
+}
+
+// set records the bytes of chunk that have been written and returns the
+// bytes of the whole download
+func (counter *byteCounter) set(chunk *paralload.Chunk, downloaded int64) int64 {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	if !chunk.Duplicate {
+		counter.written += downloaded - counter.downloaded[chunk]
+		counter.downloaded[chunk] = downloaded
+	}
+	return counter.written
+}
+
+// chunkCompleted counts the whole chunk, even if an endgame duplicate has
+// downloaded the end of it, and returns the bytes of the whole download
+func (counter *byteCounter) chunkCompleted(chunk *paralload.Chunk) int64 {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	counter.connections--
+	if !chunk.Duplicate {
+		counter.written += chunk.Length - counter.downloaded[chunk]
+		delete(counter.downloaded, chunk)
+	}
+	return counter.written
+}
+
+// progress returns the bytes written so far and the size of the file, which
+// is -1 if it is unknown
+func (counter *byteCounter) progress() (int64, int64) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	return counter.written, counter.total
+}
+
+// stats returns the progress and the speed of the download, every call
+// also records a sample for measuring the current speed
+func (counter *byteCounter) stats() transferStats {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	now := time.Now()
+	stats := transferStats{current: counter.written, total: counter.total, connections: counter.connections}
+	counter.samples = append(counter.samples, speedSample{now, stats.current})
+	for len(counter.samples) > 1 && now.Sub(counter.samples[0].time) > speedWindow {
+		counter.samples = counter.samples[1:]
+	}
+	if elapsed := now.Sub(counter.samples[0].time); elapsed > 0 {
+		stats.speed = float64(stats.current-counter.samples[0].bytes) / elapsed.Seconds()
+	}
+	if elapsed := now.Sub(counter.startTime); !counter.startTime.IsZero() && elapsed > 0 {
+		stats.averageSpeed = float64(stats.current-counter.resumed) / elapsed.Seconds()
+	}
+	return stats
+}
+
+// timeLeft estimates when the download finishes from its current speed, it
+// returns false if that isn't known
+func (stats transferStats) timeLeft() (time.Duration, bool) {
+	if stats.total < 0 || stats.speed <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(stats.total-stats.current) / stats.speed * float64(time.Second)), true
+}
+
+func (stats transferStats) String() string {
+	parts := []string{fmt.Sprintf("%.1f MiB", float64(stats.current)/(1<<20))}
+	if stats.total >= 0 {
+		parts[0] = fmt.Sprintf("%.1f / %.1f MiB", float64(stats.current)/(1<<20), float64(stats.total)/(1<<20))
+	}
+	parts = append(parts, fmt.Sprintf("%.1f MiB/s (avg %.1f MiB/s)", stats.speed/(1<<20), stats.averageSpeed/(1<<20)))
+	if timeLeft, ok := stats.timeLeft(); ok {
+		parts = append(parts, fmt.Sprintf("%v left", timeLeft.Round(time.Second)))
+	}
+	connections := fmt.Sprintf("%v connections", stats.connections)
+	if stats.connections == 1 {
+		connections = "1 connection"
+	}
+	return strings.Join(append(parts, connections), ", ")
+}
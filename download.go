@@ -1,216 +1,343 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"io"
-	"net"
-	"net/http"
-	"os"
+	"path/filepath"
 	"sync"
-	"time"
 
-	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/dialog"
-	"fyne.io/fyne/v2/layout"
-	"fyne.io/fyne/v2/widget"
 	"github.com/vbauerster/mpb/v7"
 	"github.com/vbauerster/mpb/v7/decor"
+	"ryan/paralload/paralload"
 )
 
-func startCliDownload(url string, contentLength int64, outputFile *os.File) {
-	var waitGroup sync.WaitGroup
-	var mutex sync.Mutex
-	var workerId int
-	var offset int64
-	progressContainer := mpb.New()
-	for offset = 0; offset <= contentLength; offset += cliChunkSize {
-		for activeWorkers >= int(cliWorkers) {
-			time.Sleep(200 * time.Millisecond)
-		}
-		label := fmt.Sprintf("Worker %v/%v", workerId+1, int64(contentLength/cliChunkSize)+1)
-		progressBar := progressContainer.New(
-			100,
+// CliProgress shows a bar with the total progress of the download, the bars
+// of the chunks are only shown with -showChunks
+type CliProgress struct {
+	info              *paralload.Info
+	chunkCount        int64
+	progressContainer *mpb.Progress
+	totalBar          *mpb.Bar
+	counter           *byteCounter
+	showChunks        bool
+	progressBars      map[*paralload.Chunk]*mpb.Bar
+	chunkErrors       map[*paralload.Chunk]string
+	// retrying are the chunks that have failed and haven't been completed since
+	retrying map[*paralload.Chunk]bool
+	// lastError is the latest failure of a chunk that is being retried, it
+	// is shown next to the total bar since the chunk bars may be hidden
+	lastError  string
+	maxRetries int
+	workers    int
+	finished   bool
+	mutex      sync.Mutex
+}
+
+func (cliProgress *CliProgress) Started(info *paralload.Info, chunkCount int64, completedChunks int64) {
+	cliProgress.info = info
+	cliProgress.chunkCount = chunkCount
+	cliProgress.counter.started(info, chunkCount, completedChunks)
+	if !info.AcceptsRanges {
+		fmt.Println("This server does not support HTTP byte ranges, downloading with a single stream...")
+	}
+	if len(info.Mirrors) > 1 {
+		fmt.Printf("Downloading from %v mirrors...\n", len(info.Mirrors))
+	}
+	if completedChunks > 0 {
+		fmt.Printf("Resuming download (%v/%v chunks already downloaded)...\n", completedChunks, chunkCount)
+	}
+
+	total := info.ContentLength
+	if total < 0 {
+		total = 0
+	}
+	totalBar := cliProgress.progressContainer.New(
+		total,
+		mpb.BarStyle().Padding(" "),
+		mpb.PrependDecorators(
+			decor.Name("Total", decor.WC{W: 6, C: decor.DidentRight}),
+		),
+		mpb.AppendDecorators(
+			decor.Any(func(decor.Statistics) string {
+				return cliProgress.totalStats()
+			}),
+		),
+	)
+	current, _ := cliProgress.counter.progress()
+	totalBar.SetCurrent(current)
+	cliProgress.mutex.Lock()
+	cliProgress.totalBar = totalBar
+	cliProgress.mutex.Unlock()
+}
+
+// totalStats describes the progress of the whole download next to the total bar
+func (cliProgress *CliProgress) totalStats() string {
+	stats := cliProgress.counter.stats().String()
+	cliProgress.mutex.Lock()
+	defer cliProgress.mutex.Unlock()
+	if len(cliProgress.retrying) == 1 {
+		stats += ", 1 chunk is being retried"
+	} else if len(cliProgress.retrying) > 1 {
+		stats += fmt.Sprintf(", %v chunks are being retried", len(cliProgress.retrying))
+	}
+	if len(cliProgress.retrying) > 0 {
+		stats += " (" + cliProgress.lastError + ")"
+	}
+	return stats
+}
+
+func chunkLabel(chunk *paralload.Chunk, chunkCount int64) string {
+	label := fmt.Sprintf("Worker %v/%v", chunk.Id+1, chunkCount)
+	if chunk.Split > 0 {
+		label += fmt.Sprintf(" (split %v)", chunk.Split)
+	}
+	if chunk.Duplicate {
+		label += " (duplicate)"
+	}
+	return label
+}
+
+func (cliProgress *CliProgress) ChunkStarted(chunk *paralload.Chunk) {
+	cliProgress.counter.chunkStarted()
+	if !cliProgress.showChunks {
+		return
+	}
+	var progressBar *mpb.Bar
+	if cliProgress.info.AcceptsRanges {
+		label := chunkLabel(chunk, cliProgress.chunkCount)
+		progressBar = cliProgress.progressContainer.New(
+			chunk.Length,
 			mpb.BarStyle().Padding(" "),
 			mpb.PrependDecorators(
 				decor.Name(label, decor.WC{W: len(label), C: decor.DidentRight}),
 			),
-			mpb.AppendDecorators(decor.Percentage(decor.WC{W: 6, C: decor.DidentRight})),
+			mpb.AppendDecorators(
+				decor.Percentage(decor.WC{W: 6, C: decor.DidentRight}),
+				decor.Any(func(decor.Statistics) string {
+					return cliProgress.chunkError(chunk)
+				}),
+			),
+		)
+	} else {
+		progressBar = cliProgress.progressContainer.New(
+			chunk.Length,
+			mpb.BarStyle().Padding(" "),
+			mpb.PrependDecorators(
+				decor.Name("Single stream", decor.WC{W: 13, C: decor.DidentRight}),
+			),
+			mpb.AppendDecorators(decor.CountersKibiByte("% .1f / % .1f", decor.WC{W: 6, C: decor.DidentRight})),
 		)
-		go cliDownloadChunk(url, workerId, outputFile, offset, progressBar, &waitGroup, &mutex)
-		waitGroup.Add(1)
-		workerId++
-		mutex.Lock()
-		activeWorkers++
-		mutex.Unlock()
-	}
-	waitGroup.Wait()
-	progressContainer.Wait()
-	downloading = false
-	activeWorkers = 0
-	fmt.Println("Your file has been successfully downloaded!")
-}
-
-func startDownload(url string, contentLength int64, outputFile *os.File) {
-	if !downloading {
-		enableDownloads()
-		return
 	}
+	cliProgress.mutex.Lock()
+	cliProgress.progressBars[chunk] = progressBar
+	cliProgress.mutex.Unlock()
+}
 
-	var waitGroup sync.WaitGroup
-	var mutex sync.Mutex
-	var workerId int
-	var offset int64
-	go refreshContainers()
-	for offset = 0; offset <= contentLength; offset += chunkSize {
-		if !downloading {
-			for activeWorkers > 0 {
-				time.Sleep(1 * time.Second)
-			}
-			enableDownloads()
-			return
-		}
-		for activeWorkers >= workers {
-			time.Sleep(200 * time.Millisecond)
-		}
-		label := fmt.Sprintf("Worker %v/%v", workerId+1, int64(contentLength/chunkSize)+1)
-		progressBar := widget.NewProgressBar()
-		progressBarContainer := &ChunkContainer{
-			label,
-			progressBar,
-			fyne.NewContainerWithLayout(layout.NewFormLayout(), widget.NewLabel(label), progressBar),
-		}
-		threadContainer.Add(progressBarContainer.container)
-		go downloadChunk(url, workerId, outputFile, offset, progressBarContainer, &waitGroup, &mutex)
-		waitGroup.Add(1)
-		workerId++
-		mutex.Lock()
-		activeWorkers++
-		mutex.Unlock()
-	}
-	waitGroup.Wait()
-	if downloading {
-		dialog.ShowInformation("Download Complete", "Your file has been successfully downloaded!", mainWindow)
-	}
-}
-
-func cliDownloadChunk(url string, workerId int, outputFile *os.File, offset int64, progressBar *mpb.Bar, waitGroup *sync.WaitGroup, mutex *sync.Mutex) {
-	success := false
-
-	for !success {
-		if !downloading {
-			mutex.Lock()
-			activeWorkers--
-			mutex.Unlock()
-			waitGroup.Done()
-			return
-		}
+func (cliProgress *CliProgress) progressBar(chunk *paralload.Chunk) *mpb.Bar {
+	cliProgress.mutex.Lock()
+	defer cliProgress.mutex.Unlock()
+	return cliProgress.progressBars[chunk]
+}
 
-		request, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			fmt.Println("Error: " + err.Error())
-			downloading = false
-		}
-		request.Header.Set("User-Agent", cliUserAgent)
-		request.Header.Set("Range", fmt.Sprintf("bytes=%v-%v", offset, offset+cliChunkSize-1))
-		client := &http.Client{
-			Transport: &http.Transport{
-				Dial: (&net.Dialer{
-					Timeout:   time.Duration(cliTimeout) * time.Second,
-					KeepAlive: time.Duration(cliTimeout) * time.Second,
-				}).Dial,
-				TLSHandshakeTimeout:   time.Duration(cliTimeout) * time.Second,
-				ResponseHeaderTimeout: time.Duration(cliTimeout) * time.Second,
-				IdleConnTimeout:       time.Duration(cliTimeout) * time.Second,
-			},
-		}
-		response, err := client.Do(request)
-		if err != nil {
-			continue
-		}
-		defer response.Body.Close()
-		cliChunkWriter := &CliChunkWriter{outputFile, int64(offset), int64(offset), progressBar}
-		_, err = io.Copy(cliChunkWriter, response.Body)
-		if err != nil {
-			continue
-		}
-		percentage := float64(cliChunkWriter.offset-offset) / float64(cliChunkSize) * 100
-		if int64(percentage) != 100 {
-			progressBar.SetCurrent(100)
-		}
-		success = true
+func (cliProgress *CliProgress) ChunkProgress(chunk *paralload.Chunk, downloaded int64) {
+	cliProgress.totalBar.SetCurrent(cliProgress.counter.set(chunk, downloaded))
+	if progressBar := cliProgress.progressBar(chunk); progressBar != nil {
+		progressBar.SetCurrent(downloaded)
 	}
-	mutex.Lock()
-	activeWorkers--
-	mutex.Unlock()
-	waitGroup.Done()
 }
 
-func downloadChunk(url string, workerId int, outputFile *os.File, offset int64, progressBarContainer *ChunkContainer, waitGroup *sync.WaitGroup, mutex *sync.Mutex) {
-	success := false
+func (cliProgress *CliProgress) ChunkCompleted(chunk *paralload.Chunk) {
+	cliProgress.totalBar.SetCurrent(cliProgress.counter.chunkCompleted(chunk))
+	// the size of a stream may only be known once it has ended
+	if !cliProgress.info.AcceptsRanges {
+		cliProgress.totalBar.SetTotal(-1, true)
+	}
+	cliProgress.mutex.Lock()
+	delete(cliProgress.retrying, chunk)
+	cliProgress.mutex.Unlock()
+	if progressBar := cliProgress.progressBar(chunk); progressBar != nil {
+		progressBar.SetTotal(-1, true)
+	}
+}
 
-	for !success {
-		if !downloading {
-			mutex.Lock()
-			activeWorkers--
-			mutex.Unlock()
-			waitGroup.Done()
-			return
-		}
+func (cliProgress *CliProgress) ChunkSplit(chunk *paralload.Chunk, part *paralload.Chunk) {
+	if progressBar := cliProgress.progressBar(chunk); progressBar != nil {
+		progressBar.SetTotal(chunk.Length, false)
+	}
+}
 
-		request, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			if downloading {
-				dialog.ShowInformation("Error", wrapText(err.Error()), mainWindow)
-			}
-			enableDownloads()
-			waitGroup.Done()
-			return
-		}
-		request.Header.Set("User-Agent", userAgent)
-		request.Header.Set("Range", fmt.Sprintf("bytes=%v-%v", offset, offset+chunkSize-1))
-		client := &http.Client{
-			Transport: &http.Transport{
-				Dial: (&net.Dialer{
-					Timeout:   time.Duration(timeout) * time.Second,
-					KeepAlive: time.Duration(timeout) * time.Second,
-				}).Dial,
-				TLSHandshakeTimeout:   time.Duration(timeout) * time.Second,
-				ResponseHeaderTimeout: time.Duration(timeout) * time.Second,
-				IdleConnTimeout:       time.Duration(timeout) * time.Second,
-			},
-		}
-		response, err := client.Do(request)
-		if err != nil {
-			dialog.ShowInformation("Error (retrying)", fmt.Sprintf("Worker %v has ran into an error:\n%v", workerId, wrapText(err.Error())), mainWindow)
-			continue
+func (cliProgress *CliProgress) chunkError(chunk *paralload.Chunk) string {
+	cliProgress.mutex.Lock()
+	defer cliProgress.mutex.Unlock()
+	return cliProgress.chunkErrors[chunk]
+}
+
+func (cliProgress *CliProgress) ChunkFailed(chunk *paralload.Chunk, err error) {
+	cliProgress.mutex.Lock()
+	cliProgress.chunkErrors[chunk] = fmt.Sprintf(" retry %v/%v: %v", chunk.Retries, cliProgress.maxRetries, err)
+	cliProgress.retrying[chunk] = true
+	cliProgress.lastError = fmt.Sprintf("latest: %v", err)
+	cliProgress.mutex.Unlock()
+}
+
+func (cliProgress *CliProgress) Verifying(checksum *paralload.Checksum) {
+	cliProgress.wait()
+	fmt.Printf("Verifying the %v checksum...\n", checksum.Algorithm)
+}
+
+func (cliProgress *CliProgress) MirrorFailed(url string, err error) {
+	fmt.Printf("Mirror %v has been left out: %v\n", url, err)
+}
+
+func (cliProgress *CliProgress) WorkersChanged(workers int) {
+	cliProgress.mutex.Lock()
+	cliProgress.workers = workers
+	cliProgress.mutex.Unlock()
+}
+
+func (cliProgress *CliProgress) DiskFull(err *paralload.DiskSpaceError) {
+	fmt.Printf("The disk is full, the download is paused until %v bytes are free (%v bytes are available).\n", err.Needed, err.Available)
+}
+
+func (cliProgress *CliProgress) wait() {
+	cliProgress.mutex.Lock()
+	if cliProgress.finished {
+		cliProgress.mutex.Unlock()
+		return
+	}
+	cliProgress.finished = true
+	if cliProgress.totalBar != nil && !cliProgress.totalBar.Completed() {
+		cliProgress.totalBar.Abort(false)
+	}
+	for _, progressBar := range cliProgress.progressBars {
+		if !progressBar.Completed() {
+			progressBar.Abort(false)
 		}
-		defer response.Body.Close()
-		_, err = io.Copy(
-			&ChunkWriter{
-				outputFile,
-				int64(offset),
-				int64(offset),
-				progressBarContainer,
-			},
-			response.Body,
-		)
-		if err != nil {
-			if err.Error() == "cancelled" {
-				mutex.Lock()
-				activeWorkers--
-				mutex.Unlock()
-				waitGroup.Done()
-				return
-			}
-			dialog.ShowInformation("Error (retrying)", fmt.Sprintf("Worker %v has ran into an error:\n%v", workerId, wrapText(err.Error())), mainWindow)
-			continue
+	}
+	cliProgress.mutex.Unlock()
+	cliProgress.progressContainer.Wait()
+}
+
+func startCliDownload(ctx context.Context, url string, path string, options paralload.Options) error {
+	cliProgress := &CliProgress{
+		progressContainer: mpb.New(),
+		counter:           newByteCounter(),
+		showChunks:        cliShowChunks,
+		progressBars:      make(map[*paralload.Chunk]*mpb.Bar),
+		chunkErrors:       make(map[*paralload.Chunk]string),
+		retrying:          make(map[*paralload.Chunk]bool),
+	}
+	downloader := paralload.NewDownloader(options, cliProgress)
+	cliProgress.maxRetries = downloader.Options().MaxRetries
+	err := downloader.DownloadFile(ctx, url, path)
+	cliProgress.wait()
+	if cliProgress.workers > 0 {
+		fmt.Printf("The download settled on %v workers, pass -workers %v to reuse them.\n", cliProgress.workers, cliProgress.workers)
+	}
+	return err
+}
+
+// GuiProgress shows the progress of a download in its entry of the queue
+type GuiProgress struct {
+	item       *QueueItem
+	info       *paralload.Info
+	chunkCount int64
+	maxRetries int
+	// retrying are the chunks that have failed and haven't been completed
+	// since, it is guarded by queueMutex
+	retrying map[*paralload.Chunk]bool
+}
+
+func (guiProgress *GuiProgress) Started(info *paralload.Info, chunkCount int64, completedChunks int64) {
+	guiProgress.info = info
+	guiProgress.chunkCount = chunkCount
+	queueMutex.Lock()
+	guiProgress.retrying = make(map[*paralload.Chunk]bool)
+	guiProgress.item.retryError = ""
+	queueMutex.Unlock()
+	guiProgress.item.counter.started(info, chunkCount, completedChunks)
+	guiProgress.item.chunkMap.reset(info.ContentLength, chunkCount)
+}
+
+func (guiProgress *GuiProgress) ChunksResumed(chunks []*paralload.Chunk) {
+	guiProgress.item.chunkMap.chunksResumed(chunks)
+}
+
+func (guiProgress *GuiProgress) ChunkStarted(chunk *paralload.Chunk) {
+	guiProgress.item.counter.chunkStarted()
+	label := "Single stream"
+	if guiProgress.info.AcceptsRanges {
+		label = chunkLabel(chunk, guiProgress.chunkCount)
+	}
+	guiProgress.item.chunkMap.chunkStarted(chunk, label)
+}
+
+func (guiProgress *GuiProgress) ChunkProgress(chunk *paralload.Chunk, downloaded int64) {
+	guiProgress.item.chunkMap.chunkProgress(chunk, downloaded)
+	guiProgress.item.counter.set(chunk, downloaded)
+}
+
+func (guiProgress *GuiProgress) ChunkCompleted(chunk *paralload.Chunk) {
+	guiProgress.item.counter.chunkCompleted(chunk)
+	guiProgress.item.chunkMap.chunkCompleted(chunk)
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	if guiProgress.retrying[chunk] {
+		delete(guiProgress.retrying, chunk)
+		if len(guiProgress.retrying) == 0 {
+			guiProgress.item.retryError = ""
+			guiProgress.item.updateControls()
 		}
-		success = true
 	}
+}
+
+func (guiProgress *GuiProgress) ChunkSplit(chunk *paralload.Chunk, part *paralload.Chunk) {
+	guiProgress.item.chunkMap.chunkSplit(chunk)
+}
+
+// ChunkFailed shows the latest error in the status of the download instead
+// of a dialog, since many chunks can fail at once, only a failed download
+// shows a dialog
+func (guiProgress *GuiProgress) ChunkFailed(chunk *paralload.Chunk, err error) {
+	guiProgress.item.chunkMap.chunkFailed(chunk)
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	guiProgress.retrying[chunk] = true
+	guiProgress.item.retryError = fmt.Sprintf(
+		"%v chunks are being retried, the latest error of %v (retry %v/%v) was:\n%v",
+		len(guiProgress.retrying), chunkLabel(chunk, guiProgress.chunkCount), chunk.Retries, guiProgress.maxRetries, wrapText(err.Error()),
+	)
+	guiProgress.item.updateControls()
+}
+
+func (guiProgress *GuiProgress) Verifying(checksum *paralload.Checksum) {
+	guiProgress.item.statusLabel.SetText(fmt.Sprintf("Verifying the %v checksum...", checksum.Algorithm))
+}
+
+func (guiProgress *GuiProgress) MirrorFailed(url string, err error) {
+	dialog.ShowInformation("Mirror Failed", fmt.Sprintf("%v has been left out:\n%v", wrapText(url), wrapText(err.Error())), mainWindow)
+}
+
+func (guiProgress *GuiProgress) WorkersChanged(workers int) {
+	queueMutex.Lock()
+	guiProgress.item.workers = workers
+	queueMutex.Unlock()
+}
+
+func (guiProgress *GuiProgress) DiskFull(err *paralload.DiskSpaceError) {
+	dialog.ShowInformation(
+		"Disk Full",
+		fmt.Sprintf("%v is paused until %v bytes are free\n(%v bytes are available), it continues by itself\nonce enough space has been freed up.", filepath.Base(guiProgress.item.path), err.Needed, err.Available),
+		mainWindow,
+	)
+}
 
-	mutex.Lock()
-	activeWorkers--
-	threadContainer.Remove(progressBarContainer.container)
-	mutex.Unlock()
-	waitGroup.Done()
+// newGuiDownloader creates the downloader of a queue item, it is kept as long
+// as the item so that a paused download resumes with the chunks it completed
+func newGuiDownloader(item *QueueItem) *paralload.Downloader {
+	guiProgress := &GuiProgress{item: item}
+	downloader := paralload.NewDownloader(item.options, guiProgress)
+	guiProgress.maxRetries = downloader.Options().MaxRetries
+	return downloader
 }
@@ -1,14 +1,16 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -18,105 +20,246 @@ import (
 	"fyne.io/fyne/v2/layout"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
-	"github.com/vbauerster/mpb/v7"
+	"ryan/paralload/paralload"
 )
 
 var (
-	version         string = "1.1.2"
-	application     fyne.App
-	mainWindow      fyne.Window
-	optionWindow    fyne.Window
-	downloadButton  *widget.Button
-	threadContainer *fyne.Container
-	activeWorkers   int
-	downloading     bool
-
-	workers                                     int = 16
-	cliWorkers                                  int
-	chunkSize                                   int64 = 1048576
-	cliChunkSize                                int64
-	timeout                                     int = 10
+	version        string = "1.1.2"
+	application    fyne.App
+	mainWindow     fyne.Window
+	optionWindow   fyne.Window
+	scheduleWindow fyne.Window
+	// prefilledPath is the output file that was filled in from the last probed
+	// URL, lastDirectory the directory of the last download that was added
+	prefilledPath   string
+	lastDirectory   string
+	probeGeneration int32
+
+	workers                                     workerCount = workerCount(paralload.DefaultWorkers)
+	cliWorkers                                  workerCount
+	chunkSize                                   chunkSizeValue
+	cliChunkSize                                chunkSizeValue
+	timeout                                     int = int(paralload.DefaultTimeout / time.Second)
 	cliTimeout                                  int
-	userAgent                                   string = "go-http-client/paralload"
-	cliDownloadURL, cliUserAgent, cliOutputFile string
+	retries                                     int = paralload.DefaultRetries
+	cliRetries                                  int
+	userAgent                                   string = paralload.DefaultUserAgent
+	checksum                                    string
+	preallocate                                 bool
+	rateLimiter                                 = paralload.NewRateLimiter(0)
+	cliDownloadURLs                             stringList
+	cliUserAgent, cliOutputFile, cliMirrorsFile string
+	cliChecksum, cliMetalink                    string
+	cliRateLimit, cliControlSocket              string
+	cliRateSchedule                             string
+	cliPreallocate, cliShowChunks, cliHTTP2     bool
+	cliOutputDir, cliOnExists                   string
+	cliInputFile                                string
+	cliMaxConcurrentDownloads                   int
 )
 
-type ChunkContainer struct {
-	label       string
-	progressBar *widget.ProgressBar
-	container   *fyne.Container
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ", ")
 }
 
-type ChunkWriter struct {
-	io.WriterAt
-	originalOffset       int64
-	offset               int64
-	progressBarContainer *ChunkContainer
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
 }
 
-func (chunkWriter *ChunkWriter) Write(bytes []byte) (int, error) {
-	if downloading {
-		count, err := chunkWriter.WriteAt(bytes, chunkWriter.offset)
-		chunkWriter.offset += int64(count)
-		chunkWriter.progressBarContainer.progressBar.SetValue(
-			float64(chunkWriter.offset-chunkWriter.originalOffset) / float64(chunkSize),
-		)
-		return count, err
-	} else {
-		return 0, errors.New("cancelled")
+// workerCount is a number of workers or 0 (written as "auto") to let the
+// download pick the amount of workers based on the throughput
+type workerCount int
+
+func (count workerCount) String() string {
+	if count == 0 {
+		return "auto"
 	}
+	return strconv.Itoa(int(count))
 }
 
-type CliChunkWriter struct {
-	io.WriterAt
-	originalOffset int64
-	offset         int64
-	progressBar    *mpb.Bar
+func (count *workerCount) Set(value string) error {
+	if strings.TrimSpace(value) == "auto" {
+		*count = 0
+		return nil
+	}
+	number, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || number < 1 {
+		return fmt.Errorf("\"%v\" is an invalid number!", value)
+	}
+	*count = workerCount(number)
+	return nil
 }
 
-func (cliChunkWriter *CliChunkWriter) Write(bytes []byte) (int, error) {
-	if downloading {
-		count, err := cliChunkWriter.WriteAt(bytes, cliChunkWriter.offset)
-		cliChunkWriter.offset += int64(count)
-		cliChunkWriter.progressBar.SetCurrent(int64(
-			float64(cliChunkWriter.offset-cliChunkWriter.originalOffset) / float64(cliChunkSize) * 100,
-		))
-		return count, err
-	} else {
-		return 0, errors.New("cancelled")
+func (count workerCount) options(options *paralload.Options) {
+	options.Workers = int(count)
+	options.AutoWorkers = count == 0
+}
+
+// chunkSizeValue is a chunk size in bytes or 0 (written as "auto") to pick
+// one based on the file size, the round-trip time and the workers
+type chunkSizeValue int64
+
+func (size chunkSizeValue) String() string {
+	if size == 0 {
+		return "auto"
+	}
+	return strconv.FormatInt(int64(size), 10)
+}
+
+func (size *chunkSizeValue) Set(value string) error {
+	if strings.TrimSpace(value) == "auto" {
+		*size = 0
+		return nil
+	}
+	number, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || number < 1 {
+		return fmt.Errorf("\"%v\" is an invalid number!", value)
 	}
+	*size = chunkSizeValue(number)
+	return nil
 }
 
 func main() {
-	flag.StringVar(&cliDownloadURL, "url", "", "The URL of the file you want to download")
+	flag.Var(&cliDownloadURLs, "url", "The URL of the file you want to download, pass it multiple times to download from several mirrors")
+	flag.StringVar(&cliMirrorsFile, "mirrors", "", "A file with additional mirror URLs of the file (one per line)")
 	flag.StringVar(&cliUserAgent, "userAgent", userAgent, "The user agent to use when making requests")
 	flag.StringVar(&cliOutputFile, "output", "", "The file that should store the downloaded data")
-	flag.IntVar(&cliWorkers, "workers", workers, "The amount of workers to use when downloading")
-	flag.Int64Var(&cliChunkSize, "chunkSize", int64(chunkSize), "The amount of workers to use when downloading")
+	flag.StringVar(&cliOutputDir, "output-dir", "", "The directory to store the file in, named after the server's suggestion or the URL (used instead of -output)")
+	cliWorkers = workers
+	flag.Var(&cliWorkers, "workers", "The amount of workers to use when downloading, or \"auto\" to adjust it to the throughput")
+	cliChunkSize = chunkSize
+	flag.Var(&cliChunkSize, "chunkSize", "The amount of bytes every request downloads, or \"auto\" to pick it based on the file size and latency")
 	flag.IntVar(&cliTimeout, "timeout", timeout, "The amount of seconds to wait before timing out")
+	flag.IntVar(&cliRetries, "retries", retries, "The amount of times a chunk is retried before the download fails (0 to never retry)")
+	flag.StringVar(&cliChecksum, "checksum", "", "The expected checksum of the file (md5, sha1, sha256, sha512 or blake3), e.g. sha256:<digest> or sha256:<URL of a SHA256SUMS file>")
+	flag.StringVar(&cliRateLimit, "limit-rate", "unlimited", "The maximum combined download speed in bytes per second, e.g. 500K or 5M")
+	flag.StringVar(&cliRateSchedule, "rate-schedule", "", "Rate limits for times of the day, e.g. \"09:00-18:00=2M,18:00-09:00=unlimited\" (other times use -limit-rate)")
+	flag.StringVar(&cliControlSocket, "control-socket", "", "A unix socket that accepts commands such as \"limit-rate 2M\" while downloading")
+	flag.StringVar(&cliOnExists, "on-exists", "", "What to do if the output file exists: fail, overwrite, rename, resume or skip-if-same (default rename with -output-dir and fail otherwise)")
+	flag.BoolVar(&cliHTTP2, "http2", false, "Multiplex the chunks over a single HTTP/2 connection if the server supports it (fewer handshakes, but usually slower)")
+	flag.BoolVar(&cliShowChunks, "showChunks", false, "Show a progress bar for every chunk below the total progress")
+	flag.BoolVar(&cliPreallocate, "preallocate", false, "Reserve the space for the whole file before downloading (Linux only)")
+	flag.StringVar(&cliInputFile, "input-file", "", "A file listing the URLs to download, one file per line with indented options such as out=, dir=, checksum= and header= (as in aria2)")
+	flag.IntVar(&cliMaxConcurrentDownloads, "max-concurrent-downloads", 3, "The amount of files of -input-file that are downloaded at the same time, they share the workers")
+	flag.StringVar(&cliMetalink, "metalink", "", "A metalink (.meta4) file or URL describing the files to download and their mirrors")
 	displayVersion := flag.Bool("version", false, "Display the current version of Paralload")
 	flag.Parse()
 	if *displayVersion {
 		fmt.Printf("Paralload %v\n", version)
 		return
 	}
-	if cliDownloadURL != "" {
-		if cliOutputFile == "" {
-			fmt.Println("Please provide an output file!")
+	if len(cliDownloadURLs) > 0 || cliMetalink != "" || cliInputFile != "" {
+		if cliOutputFile != "" && cliOutputDir != "" {
+			fmt.Println("Please provide either an output file or an output directory!")
 			return
 		}
-		if cliWorkers < 1 {
-			fmt.Printf("\"%v\" is an invalid number!\n", cliWorkers)
+		if cliInputFile != "" && cliOutputFile != "" {
+			fmt.Println("The output file is named in the input file, use -output-dir for the directory!")
 			return
 		}
-		if cliChunkSize < 1 {
-			fmt.Printf("\"%v\" is an invalid number!\n", cliChunkSize)
+		existsPolicy := paralload.ExistsFail
+		if cliOutputFile == "" {
+			existsPolicy = paralload.ExistsRename
+		}
+		if cliOnExists != "" {
+			policy, err := paralload.ParseExistsPolicy(cliOnExists)
+			if err != nil {
+				fmt.Println("Error: " + err.Error())
+				return
+			}
+			existsPolicy = policy
+		}
+		if cliOutputDir != "" {
+			if err := os.MkdirAll(cliOutputDir, 0755); err != nil {
+				fmt.Println("The output directory could not be created: " + err.Error())
+				os.Exit(1)
+			}
+		}
+		if cliRetries < 0 {
+			fmt.Printf("\"%v\" is an invalid number!\n", cliRetries)
 			return
 		}
-		fmt.Printf("Workers: %v, Chunk Size: %v bytes, Timeout: %vs. Starting download...\n", cliWorkers, cliChunkSize, cliTimeout)
-		result := startCliDownloadManager(cliDownloadURL, cliOutputFile, cliWorkers, cliChunkSize, cliTimeout, cliUserAgent)
+		options := paralload.Options{
+			ChunkSize:   int64(cliChunkSize),
+			Timeout:     time.Duration(cliTimeout) * time.Second,
+			UserAgent:   cliUserAgent,
+			MaxRetries:  maxRetries(cliRetries),
+			Preallocate: cliPreallocate,
+			HTTP2:       cliHTTP2,
+			RateLimiter: rateLimiter,
+		}
+		cliWorkers.options(&options)
+		rate, err := paralload.ParseRate(cliRateLimit)
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+			os.Exit(1)
+		}
+		rateLimiter.SetLimit(rate)
+		schedule, err := paralload.ParseRateSchedule(cliRateSchedule)
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+			os.Exit(1)
+		}
+		rateLimiter.SetSchedule(schedule)
+		if len(cliDownloadURLs) > 1 {
+			options.Mirrors = cliDownloadURLs[1:]
+		}
+		if cliMirrorsFile != "" {
+			mirrors, err := readMirrorsFile(cliMirrorsFile)
+			if err != nil {
+				fmt.Println("Unable to read the mirrors file: " + err.Error())
+				os.Exit(1)
+			}
+			options.Mirrors = append(options.Mirrors, mirrors...)
+		}
+		if cliChecksum != "" {
+			parsedChecksum, err := paralload.ParseChecksum(cliChecksum)
+			if err != nil {
+				fmt.Println("Error: " + err.Error())
+				os.Exit(1)
+			}
+			options.Checksum = parsedChecksum
+		}
+		chunkSizeText := "auto"
+		if cliChunkSize != 0 {
+			chunkSizeText = fmt.Sprintf("%v bytes", int64(cliChunkSize))
+		}
+		fmt.Printf("Workers: %v, Chunk Size: %v, Timeout: %vs. Starting download...\n", cliWorkers, chunkSizeText, cliTimeout)
+		if rate > 0 {
+			fmt.Printf("Limiting the download speed to %v/s\n", paralload.FormatRate(rate))
+		}
+		if len(schedule) > 0 {
+			fmt.Printf("Using the rate schedule %v\n", schedule)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		if cliControlSocket != "" {
+			if err := startControlSocket(ctx, cliControlSocket); err != nil {
+				fmt.Println("Unable to create the control socket: " + err.Error())
+				os.Exit(1)
+			}
+		}
+		var result int
+		if cliInputFile != "" {
+			result = startCliBatchDownloads(ctx, cliInputFile, cliOutputDir, existsPolicy, cliMaxConcurrentDownloads, options)
+		} else if cliMetalink != "" {
+			result = startCliMetalinkDownloads(ctx, cliMetalink, cliOutputFile, cliOutputDir, existsPolicy, options)
+		} else {
+			path := cliOutputFile
+			if path == "" {
+				path, err = outputPath(ctx, cliDownloadURLs[0], cliOutputDir, options)
+				if err != nil {
+					fmt.Println("Error: " + err.Error())
+					os.Exit(1)
+				}
+			}
+			result = startCliDownloadManager(ctx, cliDownloadURLs[0], path, existsPolicy, options)
+		}
+		stop()
 		if result != 0 {
-			return
+			os.Exit(result)
 		}
 	} else {
 		application = app.New()
@@ -128,6 +271,9 @@ func main() {
 		urlContainer := fyne.NewContainerWithLayout(layout.NewFormLayout(), urlLabel, urlEntry)
 		pathLabel := widget.NewLabel("Output File")
 		pathEntry := widget.NewEntry()
+		urlEntry.OnChanged = func(text string) {
+			go prefillOutputFile(text, pathEntry)
+		}
 		pathBrowseButton := widget.NewButtonWithIcon("", theme.FileIcon(), func() {
 			dialog.ShowFileSave(func(uri fyne.URIWriteCloser, err error) {
 				if err != nil {
@@ -142,45 +288,102 @@ func main() {
 		pathOptionsContainer := fyne.NewContainerWithLayout(layout.NewFormLayout(), pathLabel, pathEntry)
 		pathContainer := fyne.NewContainerWithLayout(layout.NewBorderLayout(nil, nil, nil, pathBrowseButton), pathOptionsContainer, pathBrowseButton)
 		advancedOptionsButton := widget.NewButtonWithIcon("Advanced Options", theme.SettingsIcon(), showAdvancedOptions)
-		downloadButton = widget.NewButtonWithIcon("Download", theme.DownloadIcon(), func() {
-			go startDownloadManager(urlEntry, pathEntry)
+		downloadButton := widget.NewButtonWithIcon("Add to Queue", theme.DownloadIcon(), func() {
+			go addDownload(urlEntry, pathEntry)
 		})
 		optionContainer := fyne.NewContainerWithLayout(layout.NewVBoxLayout(), urlContainer, pathContainer, advancedOptionsButton, downloadButton)
-		threadContainer = fyne.NewContainerWithLayout(
-			layout.NewVBoxLayout(),
-			layout.NewSpacer(),
-			fyne.NewContainerWithLayout(layout.NewCenterLayout(), widget.NewLabel("There are no active workers...")),
-			layout.NewSpacer(),
-		)
+		queueContainer = fyne.NewContainerWithLayout(layout.NewVBoxLayout())
+		showEmptyQueue()
+		loadQueue()
+		go refreshQueue()
 
 		mainWindow.Resize(fyne.Size{Width: 600, Height: 500})
 		mainWindow.SetContent(
 			fyne.NewContainerWithLayout(
 				layout.NewBorderLayout(optionContainer, nil, nil, nil),
 				optionContainer,
-				container.NewVScroll(threadContainer),
+				container.NewVScroll(queueContainer),
 			),
 		)
 		mainWindow.ShowAndRun()
 	}
 }
 
-func refreshContainers() {
-	for downloading || activeWorkers > 0 {
-		threadContainer.Refresh()
-		time.Sleep(100 * time.Millisecond)
+// maxRetries turns the retries entered by the user into Options.MaxRetries,
+// where 0 would pick the default
+func maxRetries(retries int) int {
+	if retries == 0 {
+		return paralload.NoRetries
+	}
+	return retries
+}
+
+// outputPath probes url and returns the path in directory named after the file
+func outputPath(ctx context.Context, url string, directory string, options paralload.Options) (string, error) {
+	info, err := paralload.NewDownloader(options, nil).Probe(ctx, url)
+	if err != nil {
+		return "", err
 	}
+	return filepath.Join(directory, info.FileName), nil
 }
 
-func enableDownloads() {
-	downloadButton.SetText("Download")
-	downloadButton.Enable()
-	downloading = false
-	activeWorkers = 0
-	threadContainer.RemoveAll()
-	threadContainer.Add(layout.NewSpacer())
-	threadContainer.Add(fyne.NewContainerWithLayout(layout.NewCenterLayout(), widget.NewLabel("There are no active workers...")))
-	threadContainer.Add(layout.NewSpacer())
+// downloadDirectory returns the directory output files are suggested in
+func downloadDirectory() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	if fileInfo, err := os.Stat(filepath.Join(home, "Downloads")); err == nil && fileInfo.IsDir() {
+		return filepath.Join(home, "Downloads")
+	}
+	return home
+}
+
+// prefillOutputFile fills in the output file once the URL has stopped
+// changing for a moment, unless the user has chosen a path themselves
+func prefillOutputFile(urls string, pathEntry *widget.Entry) {
+	generation := atomic.AddInt32(&probeGeneration, 1)
+	time.Sleep(500 * time.Millisecond)
+	fields := strings.Fields(urls)
+	if len(fields) == 0 || atomic.LoadInt32(&probeGeneration) != generation {
+		return
+	}
+	if pathEntry.Text != "" && pathEntry.Text != prefilledPath {
+		return
+	}
+	directory := lastDirectory
+	if prefilledPath != "" {
+		directory = filepath.Dir(prefilledPath)
+	} else if directory == "" {
+		directory = downloadDirectory()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	options := paralload.Options{Timeout: time.Duration(timeout) * time.Second, UserAgent: userAgent}
+	path, err := outputPath(ctx, fields[0], directory, options)
+	if err != nil || atomic.LoadInt32(&probeGeneration) != generation {
+		return
+	}
+	path = paralload.UniquePath(path)
+	if pathEntry.Text == "" || pathEntry.Text == prefilledPath {
+		prefilledPath = path
+		pathEntry.SetText(path)
+	}
+}
+
+func readMirrorsFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var mirrors []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			mirrors = append(mirrors, line)
+		}
+	}
+	return mirrors, nil
 }
 
 func wrapText(text string) string {
@@ -197,54 +400,91 @@ func wrapText(text string) string {
 	return output
 }
 
-func startCliDownloadManager(url string, path string, workers int, chunkSize int64, timeout int, userAgent string) int {
-	fmt.Println("Sending HEAD request to " + url + "...")
-	request, err := http.NewRequest("HEAD", url, nil)
-	if err != nil {
+func startCliDownloadManager(ctx context.Context, url string, path string, existsPolicy paralload.ExistsPolicy, options paralload.Options) int {
+	resolvedPath, err := paralload.NewDownloader(options, nil).ResolveExisting(ctx, url, path, existsPolicy)
+	if err == paralload.ErrUpToDate {
+		fmt.Printf("%v is already up to date, skipping it\n", path)
+		return 0
+	} else if err != nil {
 		fmt.Println("Error: " + err.Error())
+		var existsError *paralload.ExistsError
+		if errors.As(err, &existsError) {
+			fmt.Println("Pass -on-exists to overwrite, rename, resume or skip existing files")
+		}
 		return 1
 	}
-	outputFile, err := os.Create(path)
-	if err != nil {
-		fmt.Println("The output file could not be created: " + err.Error())
+	path = resolvedPath
+	options.StatePath = paralload.StatePath(path)
+	options.Continue = existsPolicy == paralload.ExistsResume
+	fmt.Println("Saving the file as " + path)
+
+	fmt.Println("Sending HEAD request to " + url + "...")
+	err = startCliDownload(ctx, url, path, options)
+	if err != nil && ctx.Err() != nil {
+		fmt.Println("The download has been cancelled, run the same command again to resume it")
 		return 1
-	}
-	request.Header.Set("User-Agent", userAgent)
-	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
-	response, err := client.Do(request)
-	if err != nil {
+	} else if err != nil {
 		fmt.Println("Error: " + err.Error())
 		return 1
 	}
-	if response.Header.Get("Accept-Ranges") != "bytes" {
-		fmt.Println("Error: This server does not support HTTP byte ranges")
-		return 1
+	if options.Checksum != nil {
+		fmt.Printf("The %v checksum has been verified\n", options.Checksum.Algorithm)
 	}
-	if response.Header.Get("Content-Length") == "" {
-		fmt.Println("Error: This server does not provide the Content-Length header")
-		return 1
+	fmt.Println("Your file has been successfully downloaded!")
+	return 0
+}
+
+func startCliMetalinkDownloads(ctx context.Context, metalinkPath string, path string, directory string, existsPolicy paralload.ExistsPolicy, options paralload.Options) int {
+	var metalink *paralload.Metalink
+	var err error
+	if strings.HasPrefix(metalinkPath, "http://") || strings.HasPrefix(metalinkPath, "https://") {
+		fmt.Println("Fetching metalink from " + metalinkPath + "...")
+		metalink, err = paralload.NewDownloader(options, nil).FetchMetalink(ctx, metalinkPath)
+	} else {
+		var metalinkFile *os.File
+		metalinkFile, err = os.Open(metalinkPath)
+		if err == nil {
+			metalink, err = paralload.ParseMetalink(metalinkFile)
+			metalinkFile.Close()
+		}
 	}
-	contentLength, err := strconv.ParseInt(response.Header.Get("Content-Length"), 10, 64)
 	if err != nil {
-		fmt.Println("Error: This server does not provide a valid Content-Length header")
+		fmt.Println("Unable to read the metalink: " + err.Error())
+		return 1
+	}
+	if path != "" && len(metalink.Files) > 1 {
+		fmt.Printf("The metalink describes %v files, an output file can only be provided for a single file!\n", len(metalink.Files))
 		return 1
 	}
 
-	downloading = true
-	startCliDownload(url, contentLength, outputFile)
-	return 0
-}
-
-func startDownloadManager(urlEntry *widget.Entry, pathEntry *widget.Entry) {
-	if downloading {
-		downloading = false
-		downloadButton.SetText("Cancelling...")
-		downloadButton.Disable()
-		return
+	result := 0
+	for _, file := range metalink.Files {
+		fileOptions := options
+		url, err := file.Apply(&fileOptions)
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+			result = 1
+			continue
+		}
+		outputPath := path
+		if outputPath == "" {
+			outputPath = filepath.Join(directory, file.FileName())
+		}
+		fmt.Printf("Downloading %v...\n", file.FileName())
+		if startCliDownloadManager(ctx, url, outputPath, existsPolicy, fileOptions) != 0 {
+			result = 1
+			if ctx.Err() != nil {
+				break
+			}
+		}
 	}
+	return result
+}
 
-	url := strings.TrimSpace(urlEntry.Text)
-	if url == "" {
+// addDownload adds the download of the entered URL to the queue
+func addDownload(urlEntry *widget.Entry, pathEntry *widget.Entry) {
+	urls := strings.Fields(urlEntry.Text)
+	if len(urls) == 0 {
 		dialog.ShowInformation("No URL", "Please specify a download URL", mainWindow)
 		return
 	}
@@ -253,58 +493,90 @@ func startDownloadManager(urlEntry *widget.Entry, pathEntry *widget.Entry) {
 		dialog.ShowInformation("No Path", "Please specify a file path", mainWindow)
 		return
 	}
-	outputFile, err := os.Create(path)
-	if err != nil {
-		dialog.ShowInformation("Error", "The output file could not be created:\n"+wrapText(err.Error()), mainWindow)
+	if isQueued(path) {
+		dialog.ShowInformation("Already Queued", "Another download in the queue\nis saved to this file already.", mainWindow)
 		return
 	}
-	downloadButton.SetText("Cancel Download")
-	downloading = true
-	threadContainer.RemoveAll()
-
-	request, err := http.NewRequest("HEAD", url, nil)
-	if err != nil {
-		if downloading {
-			dialog.ShowInformation("Error", wrapText(err.Error()), mainWindow)
-		}
-		enableDownloads()
-		return
+	options := paralload.Options{
+		ChunkSize:   int64(chunkSize),
+		Timeout:     time.Duration(timeout) * time.Second,
+		UserAgent:   userAgent,
+		MaxRetries:  maxRetries(retries),
+		StatePath:   paralload.StatePath(path),
+		Mirrors:     urls[1:],
+		Preallocate: preallocate,
+		RateLimiter: rateLimiter,
 	}
-	request.Header.Set("User-Agent", userAgent)
-	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
-	response, err := client.Do(request)
-	if err != nil {
-		if downloading {
-			dialog.ShowInformation("Error", wrapText(err.Error()), mainWindow)
+	workers.options(&options)
+	if checksum != "" {
+		parsedChecksum, err := paralload.ParseChecksum(checksum)
+		if err != nil {
+			dialog.ShowInformation("Checksum", wrapText(err.Error()), mainWindow)
+			return
 		}
-		enableDownloads()
-		return
+		options.Checksum = parsedChecksum
 	}
-	if response.Header.Get("Accept-Ranges") != "bytes" {
-		if downloading {
-			dialog.ShowInformation("Unsupported", "This server does not support HTTP byte ranges", mainWindow)
+	if _, err := os.Stat(path); err == nil {
+		policy, ok := askExistsPolicy(path)
+		if !ok {
+			return
 		}
-		enableDownloads()
-		return
-	}
-	if response.Header.Get("Content-Length") == "" {
-		if downloading {
-			dialog.ShowInformation("Unsupported", "This server does not provide the Content-Length header", mainWindow)
+		path, err = paralload.NewDownloader(options, nil).ResolveExisting(context.Background(), urls[0], path, policy)
+		if err == paralload.ErrUpToDate {
+			dialog.ShowInformation("Up To Date", "The file has not changed on the server,\nthere is nothing to download.", mainWindow)
+			return
+		} else if err != nil {
+			dialog.ShowInformation("Error", wrapText(err.Error()), mainWindow)
+			return
 		}
-		enableDownloads()
-		return
+		options.StatePath = paralload.StatePath(path)
+		options.Continue = policy == paralload.ExistsResume
 	}
-	contentLength, err := strconv.ParseInt(response.Header.Get("Content-Length"), 10, 64)
-	if err != nil {
-		if downloading {
-			dialog.ShowInformation("Unsupported", "This server does not provide a valid Content-Length header", mainWindow)
+
+	addToQueue(newQueueItem(urls[0], path, options))
+	lastDirectory = filepath.Dir(path)
+	prefilledPath = ""
+	urlEntry.SetText("")
+	pathEntry.SetText("")
+}
+
+// askExistsPolicy asks what should happen to an existing output file, it
+// returns false if the download should not start
+func askExistsPolicy(path string) (paralload.ExistsPolicy, bool) {
+	choice := make(chan paralload.ExistsPolicy, 1)
+	var existsDialog dialog.Dialog
+	choose := func(policy paralload.ExistsPolicy) func() {
+		return func() {
+			choice <- policy
+			existsDialog.Hide()
 		}
-		enableDownloads()
-		return
 	}
-
-	startDownload(url, contentLength, outputFile)
-	enableDownloads()
+	buttonContainer := fyne.NewContainerWithLayout(
+		layout.NewGridLayout(2),
+		widget.NewButtonWithIcon("Overwrite", theme.DocumentSaveIcon(), choose(paralload.ExistsOverwrite)),
+		widget.NewButtonWithIcon("Keep Both", theme.ContentCopyIcon(), choose(paralload.ExistsRename)),
+		widget.NewButtonWithIcon("Resume", theme.MediaPlayIcon(), choose(paralload.ExistsResume)),
+		widget.NewButtonWithIcon("Skip If Unchanged", theme.ConfirmIcon(), choose(paralload.ExistsSkipIfSame)),
+	)
+	existsDialog = dialog.NewCustom(
+		"File Exists",
+		"Cancel",
+		fyne.NewContainerWithLayout(
+			layout.NewVBoxLayout(),
+			widget.NewLabel(wrapText(path)+"\nalready exists, what should happen to it?"),
+			buttonContainer,
+		),
+		mainWindow,
+	)
+	existsDialog.SetOnClosed(func() {
+		select {
+		case choice <- paralload.ExistsFail:
+		default:
+		}
+	})
+	existsDialog.Show()
+	policy := <-choice
+	return policy, policy != paralload.ExistsFail
 }
 
 func showAdvancedOptions() {
@@ -322,35 +594,58 @@ func showAdvancedOptions() {
 
 	workersLabel := widget.NewLabel("Workers")
 	workersEntry := widget.NewEntry()
-	workersEntry.SetText(strconv.Itoa(workers))
+	workersEntry.SetText(workers.String())
 	workersContainer := fyne.NewContainerWithLayout(layout.NewFormLayout(), workersLabel, workersEntry)
 	chunkSizeLabel := widget.NewLabel("Chunk Size")
 	chunkSizeEntry := widget.NewEntry()
-	chunkSizeEntry.SetText(strconv.FormatInt(chunkSize, 10))
+	chunkSizeEntry.SetPlaceHolder("auto or a number of bytes")
+	chunkSizeEntry.SetText(chunkSize.String())
 	chunkSizeContainer := fyne.NewContainerWithLayout(layout.NewFormLayout(), chunkSizeLabel, chunkSizeEntry)
 	timeoutLabel := widget.NewLabel("Timeout")
 	timeoutEntry := widget.NewEntry()
 	timeoutEntry.SetText(strconv.Itoa(timeout))
 	timeoutContainer := fyne.NewContainerWithLayout(layout.NewFormLayout(), timeoutLabel, timeoutEntry)
+	retriesLabel := widget.NewLabel("Retries")
+	retriesEntry := widget.NewEntry()
+	retriesEntry.SetText(strconv.Itoa(retries))
+	retriesContainer := fyne.NewContainerWithLayout(layout.NewFormLayout(), retriesLabel, retriesEntry)
 	userAgentLabel := widget.NewLabel("User Agent")
 	userAgentEntry := widget.NewEntry()
 	userAgentEntry.SetText(userAgent)
 	userAgentContainer := fyne.NewContainerWithLayout(layout.NewFormLayout(), userAgentLabel, userAgentEntry)
+	checksumLabel := widget.NewLabel("Checksum")
+	checksumEntry := widget.NewEntry()
+	checksumEntry.SetPlaceHolder("sha256:<digest or SHA256SUMS URL>")
+	checksumEntry.SetText(checksum)
+	checksumContainer := fyne.NewContainerWithLayout(layout.NewFormLayout(), checksumLabel, checksumEntry)
+	rateLimitLabel := widget.NewLabel("Rate Limit")
+	rateLimitEntry := widget.NewEntry()
+	rateLimitEntry.SetPlaceHolder("unlimited or a speed such as 500K or 5M")
+	rateLimitEntry.SetText(paralload.FormatRate(rateLimiter.Limit()))
+	rateLimitContainer := fyne.NewContainerWithLayout(layout.NewFormLayout(), rateLimitLabel, rateLimitEntry)
+	concurrentDownloadsLabel := widget.NewLabel("Concurrent Downloads")
+	concurrentDownloadsEntry := widget.NewEntry()
+	concurrentDownloadsEntry.SetText(strconv.Itoa(maxConcurrentDownloads))
+	concurrentDownloadsContainer := fyne.NewContainerWithLayout(layout.NewFormLayout(), concurrentDownloadsLabel, concurrentDownloadsEntry)
+	preallocateCheck := widget.NewCheck("Reserve the space for the whole file before downloading", nil)
+	preallocateCheck.SetChecked(preallocate)
+	preallocateContainer := fyne.NewContainerWithLayout(layout.NewFormLayout(), widget.NewLabel("Preallocate"), preallocateCheck)
+	rateScheduleButton := widget.NewButtonWithIcon("Rate Schedule", theme.HistoryIcon(), showRateSchedule)
 
 	saveButton := widget.NewButtonWithIcon("Save", theme.DocumentSaveIcon(), func() {
-		if downloading {
-			dialog.ShowInformation("Download In Progress", "There is an active download in the background!", optionWindow)
+		rate, err := paralload.ParseRate(rateLimitEntry.Text)
+		if err != nil {
+			dialog.ShowInformation("Rate Limit", wrapText(err.Error()), optionWindow)
 			return
 		}
-
-		workersCount, err := strconv.Atoi(workersEntry.Text)
-		if err != nil || workersCount < 1 {
-			dialog.ShowInformation("Workers", fmt.Sprintf("\"%v\" is an invalid number!", workersEntry.Text), optionWindow)
+		var workersCount workerCount
+		if err := workersCount.Set(workersEntry.Text); err != nil {
+			dialog.ShowInformation("Workers", err.Error(), optionWindow)
 			return
 		}
-		chunkSizeCount, err := strconv.ParseInt(chunkSizeEntry.Text, 10, 64)
-		if err != nil || chunkSizeCount < 1 {
-			dialog.ShowInformation("Chunk Size", fmt.Sprintf("\"%v\" is an invalid number!", chunkSizeEntry.Text), optionWindow)
+		var chunkSizeCount chunkSizeValue
+		if err := chunkSizeCount.Set(chunkSizeEntry.Text); err != nil {
+			dialog.ShowInformation("Chunk Size", err.Error(), optionWindow)
 			return
 		}
 		timeoutTime, err := strconv.Atoi(timeoutEntry.Text)
@@ -358,10 +653,35 @@ func showAdvancedOptions() {
 			dialog.ShowInformation("Timeout", fmt.Sprintf("\"%v\" is an invalid number!", timeoutEntry.Text), optionWindow)
 			return
 		}
+		retriesCount, err := strconv.Atoi(retriesEntry.Text)
+		if err != nil || retriesCount < 0 {
+			dialog.ShowInformation("Retries", fmt.Sprintf("\"%v\" is an invalid number!", retriesEntry.Text), optionWindow)
+			return
+		}
+		concurrentDownloads, err := strconv.Atoi(concurrentDownloadsEntry.Text)
+		if err != nil || concurrentDownloads < 1 {
+			dialog.ShowInformation("Concurrent Downloads", fmt.Sprintf("\"%v\" is an invalid number!", concurrentDownloadsEntry.Text), optionWindow)
+			return
+		}
+		checksumText := strings.TrimSpace(checksumEntry.Text)
+		if checksumText != "" {
+			if _, err := paralload.ParseChecksum(checksumText); err != nil {
+				dialog.ShowInformation("Checksum", wrapText(err.Error()), optionWindow)
+				return
+			}
+		}
 		workers = workersCount
 		chunkSize = chunkSizeCount
 		timeout = timeoutTime
+		retries = retriesCount
 		userAgent = userAgentEntry.Text
+		checksum = checksumText
+		preallocate = preallocateCheck.Checked
+		rateLimiter.SetLimit(rate)
+		queueMutex.Lock()
+		maxConcurrentDownloads = concurrentDownloads
+		queueMutex.Unlock()
+		startQueuedDownloads()
 		optionWindow.Close()
 		optionWindow = nil
 	})
@@ -370,7 +690,13 @@ func showAdvancedOptions() {
 		workersContainer,
 		chunkSizeContainer,
 		timeoutContainer,
+		retriesContainer,
 		userAgentContainer,
+		checksumContainer,
+		rateLimitContainer,
+		concurrentDownloadsContainer,
+		preallocateContainer,
+		rateScheduleButton,
 		saveButton,
 	)
 
@@ -379,3 +705,85 @@ func showAdvancedOptions() {
 	optionWindow.SetFixedSize(true)
 	optionWindow.Show()
 }
+
+func showRateSchedule() {
+	if scheduleWindow != nil {
+		scheduleWindow.Close()
+		scheduleWindow = nil
+		return
+	}
+	scheduleWindow = application.NewWindow("Rate Schedule")
+	scheduleWindow.SetIcon(resourceIconPng)
+	scheduleWindow.SetOnClosed(func() {
+		scheduleWindow = nil
+	})
+
+	type scheduleRow struct {
+		startEntry, endEntry, rateEntry *widget.Entry
+		container                       *fyne.Container
+	}
+	var rows []*scheduleRow
+	rowContainer := fyne.NewContainerWithLayout(
+		layout.NewVBoxLayout(),
+		fyne.NewContainerWithLayout(layout.NewGridLayout(4), widget.NewLabel("From"), widget.NewLabel("To"), widget.NewLabel("Rate"), layout.NewSpacer()),
+	)
+	addRow := func(window *paralload.RateWindow) {
+		row := &scheduleRow{startEntry: widget.NewEntry(), endEntry: widget.NewEntry(), rateEntry: widget.NewEntry()}
+		row.startEntry.SetPlaceHolder("09:00")
+		row.endEntry.SetPlaceHolder("18:00")
+		row.rateEntry.SetPlaceHolder("2M")
+		if window != nil {
+			row.startEntry.SetText(fmt.Sprintf("%02d:%02d", window.Start/60, window.Start%60))
+			row.endEntry.SetText(fmt.Sprintf("%02d:%02d", window.End/60, window.End%60))
+			row.rateEntry.SetText(paralload.FormatRate(window.Rate))
+		}
+		removeButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), nil)
+		row.container = fyne.NewContainerWithLayout(layout.NewGridLayout(4), row.startEntry, row.endEntry, row.rateEntry, removeButton)
+		removeButton.OnTapped = func() {
+			for index, otherRow := range rows {
+				if otherRow == row {
+					rows = append(rows[:index], rows[index+1:]...)
+					break
+				}
+			}
+			rowContainer.Remove(row.container)
+		}
+		rows = append(rows, row)
+		rowContainer.Add(row.container)
+	}
+	schedule := rateLimiter.Schedule()
+	for index := range schedule {
+		addRow(&schedule[index])
+	}
+
+	addButton := widget.NewButtonWithIcon("Add Window", theme.ContentAddIcon(), func() {
+		addRow(nil)
+	})
+	saveButton := widget.NewButtonWithIcon("Save", theme.DocumentSaveIcon(), func() {
+		var windows []string
+		for _, row := range rows {
+			if row.startEntry.Text == "" && row.endEntry.Text == "" && row.rateEntry.Text == "" {
+				continue
+			}
+			windows = append(windows, fmt.Sprintf("%v-%v=%v", row.startEntry.Text, row.endEntry.Text, row.rateEntry.Text))
+		}
+		schedule, err := paralload.ParseRateSchedule(strings.Join(windows, ","))
+		if err != nil {
+			dialog.ShowInformation("Rate Schedule", wrapText(err.Error()), scheduleWindow)
+			return
+		}
+		rateLimiter.SetSchedule(schedule)
+		scheduleWindow.Close()
+		scheduleWindow = nil
+	})
+
+	scheduleWindow.SetContent(fyne.NewContainerWithLayout(
+		layout.NewVBoxLayout(),
+		widget.NewLabel("Times of the day that are not listed use the rate limit of the advanced options."),
+		rowContainer,
+		addButton,
+		saveButton,
+	))
+	scheduleWindow.Resize(fyne.Size{Width: 500, Height: 0})
+	scheduleWindow.Show()
+}
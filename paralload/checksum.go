@@ -0,0 +1,174 @@
+package paralload
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"lukechampine.com/blake3"
+)
+
+type Checksum struct {
+	Algorithm string
+	// Expected is the hex encoded digest, it is empty until the digest has
+	// been looked up if the checksum points to a SUMS file
+	Expected string
+	// SumsURL is the location of a SHA256SUMS style file listing the digest
+	SumsURL string
+}
+
+type ChecksumError struct {
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (checksumError *ChecksumError) Error() string {
+	return fmt.Sprintf(
+		"%v checksum mismatch (expected %v, got %v)",
+		checksumError.Algorithm, checksumError.Expected, checksumError.Actual,
+	)
+}
+
+// ParseChecksum parses "<algorithm>:<hex digest>" or "<algorithm>:<URL of a SUMS file>",
+// the supported algorithms are md5, sha1, sha256, sha512 and blake3
+func ParseChecksum(value string) (*Checksum, error) {
+	algorithm, digest, found := strings.Cut(strings.TrimSpace(value), ":")
+	if !found || digest == "" {
+		return nil, fmt.Errorf("checksum %q must be in the format <algorithm>:<digest>", value)
+	}
+	checksum := &Checksum{Algorithm: strings.ToLower(algorithm)}
+	if _, err := checksum.newHash(); err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(digest, "http://") || strings.HasPrefix(digest, "https://") {
+		checksum.SumsURL = digest
+		return checksum, nil
+	}
+	if _, err := hex.DecodeString(digest); err != nil {
+		return nil, fmt.Errorf("checksum %q is not a valid hex digest", digest)
+	}
+	checksum.Expected = strings.ToLower(digest)
+	return checksum, nil
+}
+
+func (checksum *Checksum) String() string {
+	if checksum.Expected == "" {
+		return checksum.Algorithm + ":" + checksum.SumsURL
+	}
+	return checksum.Algorithm + ":" + checksum.Expected
+}
+
+func (checksum *Checksum) newHash() (hash.Hash, error) {
+	switch checksum.Algorithm {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	case "blake3":
+		return blake3.New(32, nil), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm: %v", checksum.Algorithm)
+}
+
+// Verify hashes everything read from reader and compares it to the expected digest
+func (checksum *Checksum) Verify(reader io.Reader) error {
+	hash, err := checksum.newHash()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(hash, reader); err != nil {
+		return err
+	}
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if actual != checksum.Expected {
+		return &ChecksumError{checksum.Algorithm, checksum.Expected, actual}
+	}
+	return nil
+}
+
+// contextReader stops reading once its context is done, so that hashing a
+// large file can be cancelled
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (contextReader *contextReader) Read(bytes []byte) (int, error) {
+	if err := contextReader.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return contextReader.reader.Read(bytes)
+}
+
+// resolveChecksum looks up the digest of the file at fileURL in the SUMS file
+func (downloader *Downloader) resolveChecksum(ctx context.Context, checksum *Checksum, fileURL string) error {
+	if checksum.Expected != "" {
+		return nil
+	}
+	parsedURL, err := url.Parse(fileURL)
+	if err != nil {
+		return err
+	}
+	name := path.Base(parsedURL.Path)
+
+	request, err := downloader.newRequest(ctx, "GET", checksum.SumsURL)
+	if err != nil {
+		return err
+	}
+	response, err := downloader.newClient(downloader.options.Timeout).Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to fetch %v: %v", checksum.SumsURL, response.Status)
+	}
+
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		digest, fileName := parseSumsLine(scanner.Text())
+		if fileName == name || path.Base(fileName) == name {
+			if _, err := hex.DecodeString(digest); err != nil {
+				return fmt.Errorf("%v lists an invalid digest for %v", checksum.SumsURL, name)
+			}
+			checksum.Expected = strings.ToLower(digest)
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("%v does not list a checksum for %v", checksum.SumsURL, name)
+}
+
+// parseSumsLine understands both "<digest>  <name>" (sha256sum) and
+// "SHA256 (<name>) = <digest>" (BSD) lines
+func parseSumsLine(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	if start := strings.Index(line, " ("); start != -1 {
+		if end := strings.LastIndex(line, ") = "); end > start {
+			return line[end+4:], line[start+2 : end]
+		}
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return "", ""
+	}
+	return fields[0], strings.TrimPrefix(strings.Join(fields[1:], " "), "*")
+}
@@ -0,0 +1,26 @@
+package paralload
+
+import "testing"
+
+func TestParseSumsLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		digest   string
+		fileName string
+	}{
+		{"e3b0c442  file.iso", "e3b0c442", "file.iso"},
+		{"e3b0c442 *file.iso", "e3b0c442", "file.iso"},
+		{"e3b0c442  dir/file name.iso", "e3b0c442", "dir/file name.iso"},
+		{"  e3b0c442  file.iso  ", "e3b0c442", "file.iso"},
+		{"SHA256 (file.iso) = e3b0c442", "e3b0c442", "file.iso"},
+		{"SHA256 (file (1).iso) = e3b0c442", "e3b0c442", "file (1).iso"},
+		{"e3b0c442", "", ""},
+		{"", "", ""},
+	}
+	for _, test := range tests {
+		digest, fileName := parseSumsLine(test.line)
+		if digest != test.digest || fileName != test.fileName {
+			t.Errorf("parseSumsLine(%q) = %q, %q, want %q, %q", test.line, digest, fileName, test.digest, test.fileName)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package paralload
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// diskSpaceInterval is how often the free space is checked while a
+// download is paused because the disk is full (a variable so that tests can
+// shorten it)
+var diskSpaceInterval = 5 * time.Second
+
+type DiskSpaceError struct {
+	Path      string
+	Needed    int64
+	Available int64
+}
+
+func (diskSpaceError *DiskSpaceError) Error() string {
+	return fmt.Sprintf(
+		"there is not enough free space for %v (%v bytes are needed but only %v bytes are available)",
+		diskSpaceError.Path, diskSpaceError.Needed, diskSpaceError.Available,
+	)
+}
+
+// checkFreeSpace makes sure that the disk of file can hold size bytes,
+// space that the file already occupies is taken into account. Outputs
+// that aren't files and systems without free space information are not checked.
+func checkFreeSpace(output interface{}, size int64) error {
+	file, ok := output.(*os.File)
+	if !ok || size <= 0 {
+		return nil
+	}
+	available, err := freeSpace(filepath.Dir(file.Name()))
+	if err != nil {
+		return nil
+	}
+	needed := size - allocatedSize(file)
+	if needed > available {
+		return &DiskSpaceError{file.Name(), needed, available}
+	}
+	return nil
+}
+
+// waitForSpace pauses a worker whose write failed because the disk is full
+// until there is enough space for the rest of its segment, it always waits
+// at least diskSpaceInterval. Workers wait one after another, so the first
+// one reports the full disk and the others usually find enough space once
+// it continues. It returns false if there was enough space all along, the
+// write then failed for a reason the free space doesn't show (such as a
+// quota or exhausted metadata space).
+func (download *download) waitForSpace(ctx context.Context, segment *segment, err error) (bool, error) {
+	file, ok := download.output.(*os.File)
+	if !ok {
+		return false, err
+	}
+	download.spaceMutex.Lock()
+	defer download.spaceMutex.Unlock()
+	full := false
+	for waited := false; ; waited = true {
+		position, length := download.scheduler.remaining(segment)
+		available, spaceErr := freeSpace(filepath.Dir(file.Name()))
+		if spaceErr != nil {
+			return false, err
+		}
+		if available >= length-position && waited {
+			return full, nil
+		}
+		if diskProgress, ok := download.progress.(DiskProgress); ok && available < length-position && !full {
+			diskProgress.DiskFull(&DiskSpaceError{file.Name(), length - position, available})
+		}
+		full = full || available < length-position
+		select {
+		case <-time.After(diskSpaceInterval):
+		case <-ctx.Done():
+			return full, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !windows
+
+package paralload
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func freeSpace(directory string) (int64, error) {
+	return 0, errors.New("free space information is not available on this system")
+}
+
+func allocatedSize(file *os.File) int64 {
+	return 0
+}
+
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
@@ -0,0 +1,46 @@
+package paralload
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWaitForSpaceWithEnoughSpace(t *testing.T) {
+	defer func(interval time.Duration) { diskSpaceInterval = interval }(diskSpaceInterval)
+	diskSpaceInterval = 50 * time.Millisecond
+	file, err := os.Create(filepath.Join(t.TempDir(), "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := freeSpace(filepath.Dir(file.Name())); err != nil {
+		t.Skip("the free space is unknown on this system")
+	}
+	info := &Info{ContentLength: 1 << 20}
+	state, _ := loadState("", info, 1<<20, false)
+	download := &download{Downloader: NewDownloader(Options{}, nil), output: file}
+	download.scheduler = newScheduler(info, download.options, state, noProgress{})
+	segment := download.scheduler.next(context.Background())
+
+	// a write that fails although there is enough space (such as with a
+	// quota) still waits before it is retried, and isn't reported as a full disk
+	start := time.Now()
+	full, err := download.waitForSpace(context.Background(), segment, syscall.ENOSPC)
+	if full || err != nil {
+		t.Errorf("waitForSpace returned %v, %v, want false, nil", full, err)
+	}
+	if elapsed := time.Since(start); elapsed < diskSpaceInterval {
+		t.Errorf("waitForSpace returned after %v, want at least %v", elapsed, diskSpaceInterval)
+	}
+
+	// outputs that aren't files can't be waited for
+	download.output = &memoryFile{}
+	if _, err := download.waitForSpace(context.Background(), segment, syscall.ENOSPC); !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("waitForSpace of a memory output returned %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package paralload
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func freeSpace(directory string) (int64, error) {
+	var stat unix.Statfs_t
+	err := unix.Statfs(directory, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+// allocatedSize returns the space the file occupies on the disk, which is
+// less than its size if it is sparse
+func allocatedSize(file *os.File) int64 {
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return 0
+	}
+	if stat, ok := fileInfo.Sys().(*syscall.Stat_t); ok {
+		return int64(stat.Blocks) * 512
+	}
+	return 0
+}
+
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
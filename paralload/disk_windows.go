@@ -0,0 +1,35 @@
+package paralload
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+func freeSpace(directory string) (int64, error) {
+	path, err := windows.UTF16PtrFromString(directory)
+	if err != nil {
+		return 0, err
+	}
+	var available uint64
+	err = windows.GetDiskFreeSpaceEx(path, &available, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	return int64(available), nil
+}
+
+// allocatedSize returns the size of the file, files on Windows are not
+// sparse unless they are explicitly marked as such
+func allocatedSize(file *os.File) int64 {
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return 0
+	}
+	return fileInfo.Size()
+}
+
+func isDiskFull(err error) bool {
+	return errors.Is(err, windows.ERROR_DISK_FULL) || errors.Is(err, windows.ERROR_HANDLE_DISK_FULL)
+}
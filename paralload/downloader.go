@@ -0,0 +1,517 @@
+package paralload
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Downloader struct {
+	options  Options
+	progress Progress
+	// transport is shared by every request of the downloader, so connections
+	// (and TLS sessions) are kept alive between chunks and retries
+	transport http.RoundTripper
+
+	pause      chan struct{}
+	pauseMutex sync.Mutex
+	// pausedState keeps the completed chunks of a paused download in memory
+	pausedState *State
+}
+
+// NewDownloader creates a downloader, zero options are replaced with their
+// defaults and progress may be nil if no updates are needed
+func NewDownloader(options Options, progress Progress) *Downloader {
+	if progress == nil {
+		progress = noProgress{}
+	}
+	options = options.withDefaults()
+	return &Downloader{
+		options:   options,
+		progress:  progress,
+		transport: newTransport(options),
+		pause:     make(chan struct{}),
+	}
+}
+
+func (downloader *Downloader) Options() Options {
+	return downloader.options
+}
+
+type chunkWriter struct {
+	ctx         context.Context
+	output      io.WriterAt
+	chunk       *Chunk
+	offset      int64
+	progress    Progress
+	rateLimiter *RateLimiter
+}
+
+func (chunkWriter *chunkWriter) Write(bytes []byte) (int, error) {
+	if err := chunkWriter.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if chunkWriter.rateLimiter != nil {
+		if err := chunkWriter.rateLimiter.wait(chunkWriter.ctx, len(bytes)); err != nil {
+			return 0, err
+		}
+	}
+	count, err := chunkWriter.output.WriteAt(bytes, chunkWriter.chunk.Offset+chunkWriter.offset)
+	chunkWriter.offset += int64(count)
+	chunkWriter.progress.ChunkProgress(chunkWriter.chunk, chunkWriter.offset)
+	return count, err
+}
+
+type truncater interface {
+	Truncate(size int64) error
+}
+
+// Download probes url and writes the file into output, using byte ranges
+// if the server supports them. Chunks are spread across url and the mirrors
+// in the options that serve the same file. Outputs that can be truncated (such as
+// *os.File) are cut to the size of the file unless a download is resumed.
+func (downloader *Downloader) Download(ctx context.Context, url string, output io.WriterAt) error {
+	info, err := downloader.download(ctx, url, output)
+	if err != nil {
+		return err
+	}
+	downloader.removeState()
+	return downloader.verify(ctx, info, output)
+}
+
+// download is Download without removing the state and verifying the checksum
+func (downloader *Downloader) download(ctx context.Context, url string, output io.WriterAt) (*Info, error) {
+	defer downloader.resetPause()
+	info, err := downloader.Probe(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	expectedSize := downloader.options.ExpectedSize
+	if expectedSize > 0 && info.ContentLength != -1 && info.ContentLength != expectedSize {
+		return nil, fmt.Errorf("the server reports a size of %v bytes instead of %v bytes", info.ContentLength, expectedSize)
+	}
+
+	if !info.AcceptsRanges {
+		if err := checkFreeSpace(output, info.ContentLength); err != nil {
+			return nil, err
+		}
+		if file, ok := output.(truncater); ok {
+			if err := file.Truncate(0); err != nil {
+				return nil, err
+			}
+		}
+		if !downloader.options.WorkerBudget.acquire(ctx) {
+			return nil, ctx.Err()
+		}
+		defer downloader.options.WorkerBudget.release()
+		downloader.progress.Started(info, 1, 0)
+		// a stream can't stop at a chunk boundary, so pausing it stops right away
+		streamCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-downloader.pauseSignal():
+				cancel()
+			case <-streamCtx.Done():
+			}
+		}()
+		err := downloader.downloadStream(streamCtx, info, output)
+		if err != nil && ctx.Err() == nil && downloader.paused() {
+			return nil, ErrPaused
+		}
+		return info, err
+	}
+
+	if pieces := downloader.options.Pieces; pieces != nil {
+		if _, ok := output.(io.ReaderAt); !ok {
+			return nil, errors.New("the output can not be read to verify the pieces")
+		}
+		if int64(len(pieces.Hashes)) != ChunkCount(info.ContentLength, pieces.Length) {
+			return nil, fmt.Errorf("expected %v piece hashes but got %v", ChunkCount(info.ContentLength, pieces.Length), len(pieces.Hashes))
+		}
+	}
+	mirrors := downloader.probeMirrors(ctx, info)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	chunkSize := downloader.options.ChunkSize
+	automatic := chunkSize == 0
+	if automatic {
+		chunkSize = autoChunkSize(info.ContentLength, info.RTT, downloader.options.Workers)
+	}
+	state, resuming := downloader.pausedState, true
+	if state == nil || !state.matches(info, chunkSize, automatic) {
+		state, resuming = loadState(downloader.options.StatePath, info, chunkSize, automatic)
+	}
+	downloader.pausedState = nil
+	if file, ok := output.(*os.File); ok && downloader.options.Continue && !resuming {
+		resuming = state.continueFile(file)
+	}
+	if err := checkFreeSpace(output, info.ContentLength); err != nil {
+		return nil, err
+	}
+	if file, ok := output.(truncater); ok && !resuming {
+		if err := file.Truncate(info.ContentLength); err != nil {
+			return nil, err
+		}
+	}
+	if file, ok := output.(*os.File); ok && downloader.options.Preallocate {
+		if err := preallocate(file, info.ContentLength); err != nil {
+			return nil, fmt.Errorf("unable to preallocate %v: %w", file.Name(), err)
+		}
+	}
+	downloader.progress.Started(info, ChunkCount(info.ContentLength, state.ChunkSize), state.completedChunks())
+	if resumeProgress, ok := downloader.progress.(ResumeProgress); ok && resuming {
+		resumeProgress.ChunksResumed(state.completedChunkList())
+	}
+	scheduler := newScheduler(info, downloader.options, state, downloader.progress)
+	download := &download{
+		Downloader: downloader,
+		info:       info,
+		output:     output,
+		state:      state,
+		mirrors:    mirrors,
+		scheduler:  scheduler,
+		pool:       newWorkerPool(downloader.workers()),
+	}
+	err = download.downloadChunks(ctx)
+	if err == ErrPaused {
+		downloader.pausedState = state
+	}
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (downloader *Downloader) verify(ctx context.Context, info *Info, output io.WriterAt) error {
+	checksum := downloader.options.Checksum
+	if checksum == nil {
+		return nil
+	}
+	reader, ok := output.(io.ReaderAt)
+	if !ok {
+		return errors.New("the output can not be read to verify its checksum")
+	}
+	err := downloader.resolveChecksum(ctx, checksum, info.URL)
+	if err != nil {
+		return err
+	}
+	size := info.ContentLength
+	if size == -1 {
+		size = math.MaxInt64
+	}
+	downloader.progress.Verifying(checksum)
+	return checksum.Verify(&contextReader{ctx, io.NewSectionReader(reader, 0, size)})
+}
+
+// download holds everything belonging to a single ranged download
+type download struct {
+	*Downloader
+	info      *Info
+	output    io.WriterAt
+	state     *State
+	mirrors   *mirrorSet
+	scheduler *scheduler
+	pool      *workerPool
+	// spaceMutex makes workers wait for free space one after another
+	spaceMutex sync.Mutex
+}
+
+// workers returns the amount of workers a download starts with
+func (downloader *Downloader) workers() int {
+	if downloader.options.AutoWorkers && downloader.options.Workers > initialAutoWorkers {
+		return initialAutoWorkers
+	}
+	return downloader.options.Workers
+}
+
+func (download *download) downloadChunks(ctx context.Context) error {
+	downloadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var downloadError error
+	var once sync.Once
+	fail := func(err error) {
+		once.Do(func() {
+			downloadError = err
+			cancel()
+		})
+	}
+
+	// startCtx stops workers from waiting for the worker budget once the
+	// download is paused, the workers that are downloading keep going
+	startCtx, stopStarting := context.WithCancel(downloadCtx)
+	go func() {
+		select {
+		case <-downloadCtx.Done():
+		case <-download.pauseSignal():
+			stopStarting()
+		}
+		download.pool.close()
+	}()
+	tuned := make(chan struct{})
+	if download.options.AutoWorkers {
+		if workersProgress, ok := download.progress.(WorkersProgress); ok {
+			workersProgress.WorkersChanged(download.workers())
+		}
+		go func() {
+			download.tuneWorkers(downloadCtx, download.pool, download.workers())
+			close(tuned)
+		}()
+	} else {
+		close(tuned)
+	}
+
+	var waitGroup sync.WaitGroup
+	for worker := 0; worker < download.options.Workers; worker++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for download.pool.acquire() {
+				if !download.options.WorkerBudget.acquire(startCtx) {
+					download.pool.release()
+					return
+				}
+				var segment *segment
+				if !download.paused() {
+					segment = download.scheduler.next(downloadCtx)
+				}
+				if segment == nil {
+					download.options.WorkerBudget.release()
+					download.pool.release()
+					return
+				}
+				err := download.downloadSegment(downloadCtx, segment)
+				download.options.WorkerBudget.release()
+				download.pool.release()
+				if err != nil {
+					fail(err)
+					return
+				}
+			}
+		}()
+	}
+	waitGroup.Wait()
+	cancel()
+	<-tuned
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if downloadError == nil && download.paused() &&
+		download.state.completedChunks() < ChunkCount(download.info.ContentLength, download.state.ChunkSize) {
+		return ErrPaused
+	}
+	return downloadError
+}
+
+// downloadSegment downloads the segment until it is complete or until it has
+// lost its race against an endgame duplicate (or the other way around)
+func (download *download) downloadSegment(ctx context.Context, segment *segment) error {
+	chunk := segment.chunk
+	raceCtx := segment.race.ctx
+	var failedMirror *mirror
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if raceCtx.Err() != nil {
+			break
+		}
+		mirror := download.mirrors.pick(failedMirror)
+		if mirror == nil {
+			return ErrNoMirrors
+		}
+		chunk.Mirror = mirror.url
+		start := time.Now()
+		downloaded, err := download.fetchSegment(raceCtx, mirror.url, segment)
+		if err == nil {
+			err = download.verifyPiece(raceCtx, chunk)
+			if err != nil {
+				download.scheduler.release(segment, 0)
+			}
+		}
+		if err == nil {
+			download.mirrors.succeeded(mirror, downloaded, time.Since(start))
+			break
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		if raceCtx.Err() != nil {
+			break
+		}
+		// a full disk is not the fault of the mirror, the segment continues
+		// once there is enough space again. If the disk had enough space all
+		// along, the failed write counts as a retry so that it can't repeat forever.
+		if isDiskFull(err) {
+			full, waitErr := download.waitForSpace(raceCtx, segment, err)
+			if waitErr != nil && raceCtx.Err() == nil {
+				return waitErr
+			}
+			if !full && raceCtx.Err() == nil {
+				if chunk.Retries >= download.options.MaxRetries {
+					return download.abandon(segment, fmt.Errorf("chunk %v failed %v times, the last error was: %w", chunk.Id+1, chunk.Retries+1, err))
+				}
+				chunk.Retries++
+				download.progress.ChunkFailed(chunk, err)
+			}
+			continue
+		}
+
+		if isThrottling(err) {
+			atomic.StoreInt32(&download.pool.throttled, 1)
+		}
+		permanent := err == ErrRangesIgnored || isPermanent(err)
+		mirrorDisabled := download.mirrors.failed(mirror, permanent)
+		if mirrorDisabled {
+			if mirrorProgress, ok := download.progress.(MirrorProgress); ok {
+				mirrorProgress.MirrorFailed(mirror.url, err)
+			}
+		} else if permanent {
+			return download.abandon(segment, err)
+		}
+		if chunk.Retries >= download.options.MaxRetries {
+			return download.abandon(segment, fmt.Errorf("chunk %v failed %v times, the last error was: %w", chunk.Id+1, chunk.Retries+1, err))
+		}
+		delay := download.retryDelay(chunk.Retries, err)
+		chunk.Retries++
+		failedMirror = mirror
+		download.progress.ChunkFailed(chunk, err)
+		if !mirrorDisabled {
+			select {
+			case <-time.After(delay):
+			case <-raceCtx.Done():
+			}
+		}
+	}
+
+	if download.scheduler.finished(segment) {
+		// the chunk is only recorded once its data is on the disk, otherwise a
+		// crash could resume a download with a chunk that has never been written
+		if file, ok := download.output.(*os.File); ok && download.state.path != "" {
+			if err := file.Sync(); err != nil {
+				return fmt.Errorf("unable to sync %v: %w", file.Name(), err)
+			}
+		}
+		err := download.state.markComplete(int64(chunk.Id))
+		if err != nil {
+			return fmt.Errorf("unable to save download state: %w", err)
+		}
+	}
+	download.progress.ChunkCompleted(chunk)
+	return nil
+}
+
+// abandon gives up on a segment, which only fails the download if the
+// segment is not an endgame duplicate
+func (download *download) abandon(segment *segment, err error) error {
+	if !segment.chunk.Duplicate {
+		return err
+	}
+	download.scheduler.abandon(segment)
+	download.progress.ChunkCompleted(segment.chunk)
+	return nil
+}
+
+// verifyPiece reads a downloaded chunk back and compares it to its piece hash
+func (download *download) verifyPiece(ctx context.Context, chunk *Chunk) error {
+	pieces := download.options.Pieces
+	if pieces == nil {
+		return nil
+	}
+	checksum := &Checksum{Algorithm: pieces.Algorithm, Expected: strings.ToLower(pieces.Hashes[chunk.Id])}
+	offset := int64(chunk.Id) * pieces.Length
+	length := chunkLength(offset, pieces.Length, download.info.ContentLength)
+	return checksum.Verify(&contextReader{ctx, io.NewSectionReader(download.output.(io.ReaderAt), offset, length)})
+}
+
+// fetchSegment downloads the rest of the segment (a retry continues where
+// the previous attempt stopped) and returns the amount of bytes received
+func (download *download) fetchSegment(ctx context.Context, url string, segment *segment) (int64, error) {
+	position, length := download.scheduler.remaining(segment)
+	offset := segment.chunk.Offset + position
+	request, err := download.newRequest(ctx, "GET", url)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Range", fmt.Sprintf("bytes=%v-%v", offset, segment.chunk.Offset+length-1))
+	response, err := download.newClient(0).Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	err = checkRangeResponse(response, offset, length-position, download.info.ContentLength)
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := io.Copy(
+		&segmentWriter{ctx, download, segment},
+		io.LimitReader(response.Body, length-position),
+	)
+	if err == errSegmentEnd {
+		err = nil
+	}
+	if err == nil {
+		if position, length := download.scheduler.remaining(segment); position != length {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	return written, err
+}
+
+// newTransport allows one connection per worker to every host. With
+// Options.HTTP2, HTTP/2 servers multiplex the chunks over fewer connections
+// instead, which saves handshakes but is usually slower since all chunks
+// share the congestion window of a single TCP connection.
+func newTransport(options Options) *http.Transport {
+	timeout := options.Timeout
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   timeout,
+			KeepAlive: timeout,
+		}).DialContext,
+		ForceAttemptHTTP2:     options.HTTP2,
+		MaxConnsPerHost:       options.Workers,
+		MaxIdleConnsPerHost:   options.Workers,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		IdleConnTimeout:       timeout,
+	}
+	if !options.HTTP2 {
+		// a non-nil empty map keeps the transport from negotiating HTTP/2
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport
+}
+
+// newRequest creates a request with the user agent and the headers of the options
+func (downloader *Downloader) newRequest(ctx context.Context, method string, url string) (*http.Request, error) {
+	request, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range downloader.options.Headers {
+		request.Header[name] = values
+	}
+	request.Header.Set("User-Agent", downloader.options.UserAgent)
+	return request, nil
+}
+
+// newClient returns a client using the shared transport, timeout limits the
+// whole request (including the body) and should be 0 for downloads
+func (downloader *Downloader) newClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: downloader.transport, Timeout: timeout}
+}
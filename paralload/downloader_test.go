@@ -0,0 +1,280 @@
+package paralload
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// memoryFile is an output that keeps the download in memory
+type memoryFile struct {
+	data  []byte
+	mutex sync.Mutex
+}
+
+func (file *memoryFile) WriteAt(bytes []byte, offset int64) (int, error) {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+	if end := offset + int64(len(bytes)); end > int64(len(file.data)) {
+		file.data = append(file.data, make([]byte, end-int64(len(file.data)))...)
+	}
+	return copy(file.data[offset:], bytes), nil
+}
+
+// cancelProgress cancels the download once a number of chunks are complete
+type cancelProgress struct {
+	noProgress
+	cancel    context.CancelFunc
+	remaining int
+	mutex     sync.Mutex
+}
+
+func (progress *cancelProgress) ChunkCompleted(*Chunk) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	progress.remaining--
+	if progress.remaining == 0 {
+		progress.cancel()
+	}
+}
+
+// countingProgress counts the chunks a download has started and completed,
+// and adds up the length of the completed chunks
+type countingProgress struct {
+	noProgress
+	chunkCount int64
+	started    int
+	completed  int
+	bytes      int64
+	mutex      sync.Mutex
+}
+
+func (progress *countingProgress) Started(info *Info, chunkCount int64, completedChunks int64) {
+	progress.chunkCount = chunkCount
+}
+
+func (progress *countingProgress) ChunkStarted(*Chunk) {
+	progress.mutex.Lock()
+	progress.started++
+	progress.mutex.Unlock()
+}
+
+func (progress *countingProgress) ChunkCompleted(chunk *Chunk) {
+	progress.mutex.Lock()
+	progress.completed++
+	progress.bytes += chunk.Length
+	progress.mutex.Unlock()
+}
+
+// pauseProgress pauses its downloader once a number of chunks are complete
+// in every download, and keeps track of the chunks of the last download
+type pauseProgress struct {
+	noProgress
+	downloader *Downloader
+	after      int
+	resumed    int64
+	completed  int
+	bytes      int64
+	mutex      sync.Mutex
+}
+
+func (progress *pauseProgress) Started(info *Info, chunkCount int64, completedChunks int64) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	progress.resumed = completedChunks
+	progress.completed = 0
+	progress.bytes = 0
+}
+
+func (progress *pauseProgress) ChunkCompleted(chunk *Chunk) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	progress.completed++
+	progress.bytes += chunk.Length
+	if progress.completed == progress.after {
+		progress.downloader.Pause()
+	}
+}
+
+func TestDownload(t *testing.T) {
+	data := make([]byte, 3<<20+45)
+	rand.New(rand.NewSource(1)).Read(data)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		http.ServeContent(writer, request, "file", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	progress := &countingProgress{}
+	output := &memoryFile{}
+	err := NewDownloader(Options{Workers: 4, ChunkSize: MinChunkSize}, progress).Download(context.Background(), server.URL, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output.data, data) {
+		t.Error("the download doesn't match the file on the server")
+	}
+	if want := ChunkCount(int64(len(data)), MinChunkSize); progress.chunkCount != want {
+		t.Errorf("the download has %v chunks, want %v", progress.chunkCount, want)
+	}
+	// split off parts and endgame duplicates are reported as chunks as well
+	if progress.started < int(progress.chunkCount) || progress.started != progress.completed {
+		t.Errorf("%v chunks have been started and %v completed", progress.started, progress.completed)
+	}
+}
+
+func TestDownloadStream(t *testing.T) {
+	data := make([]byte, 1<<20+67)
+	rand.New(rand.NewSource(1)).Read(data)
+	// a flushed response has no Content-Length and no byte ranges
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.(http.Flusher).Flush()
+		if request.Method == "GET" {
+			writer.Write(data)
+		}
+	}))
+	defer server.Close()
+
+	progress := &countingProgress{}
+	output := &memoryFile{}
+	err := NewDownloader(Options{Workers: 4, ChunkSize: MinChunkSize}, progress).Download(context.Background(), server.URL, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output.data, data) {
+		t.Error("the download doesn't match the file on the server")
+	}
+	if progress.chunkCount != 1 || progress.completed != 1 {
+		t.Errorf("the stream has %v chunks and %v have been completed, want 1", progress.chunkCount, progress.completed)
+	}
+	// the completed chunk has the length of the stream, which wasn't known before
+	if progress.bytes != int64(len(data)) {
+		t.Errorf("the completed chunk has %v bytes, want %v", progress.bytes, len(data))
+	}
+}
+
+func TestDownloadFileResume(t *testing.T) {
+	data := make([]byte, 4<<20+123)
+	rand.New(rand.NewSource(1)).Read(data)
+	var served int64
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("ETag", `"test"`)
+		http.ServeContent(countingResponseWriter{writer, &served}, request, "file", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "file")
+	options := Options{Workers: 1, ChunkSize: MinChunkSize, StatePath: StatePath(path)}
+
+	// the first download is interrupted after 6 of its 17 chunks
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := NewDownloader(options, &cancelProgress{cancel: cancel, remaining: 6}).DownloadFile(ctx, server.URL, path)
+	if err != context.Canceled {
+		t.Fatalf("the interrupted download returned %v", err)
+	}
+	if _, err := os.Stat(StatePath(path)); err != nil {
+		t.Fatalf("the interrupted download didn't leave its state behind: %v", err)
+	}
+
+	atomic.StoreInt64(&served, 0)
+	if err := NewDownloader(options, nil).DownloadFile(context.Background(), server.URL, path); err != nil {
+		t.Fatal(err)
+	}
+	downloaded, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, data) {
+		t.Error("the resumed download doesn't match the file on the server")
+	}
+	// the resumed download only fetches the chunks that were missing
+	if received, want := atomic.LoadInt64(&served), int64(len(data))-6*MinChunkSize; received != want {
+		t.Errorf("the resumed download received %v bytes, want %v", received, want)
+	}
+	for _, leftover := range []string{PartPath(path), StatePath(path)} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("%v is left behind", filepath.Base(leftover))
+		}
+	}
+}
+
+func TestDownloadFilePause(t *testing.T) {
+	data := make([]byte, 4<<20+123)
+	rand.New(rand.NewSource(1)).Read(data)
+	var served int64
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("ETag", `"test"`)
+		http.ServeContent(countingResponseWriter{writer, &served}, request, "file", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "file")
+	options := Options{Workers: 2, ChunkSize: MinChunkSize, StatePath: StatePath(path)}
+	progress := &pauseProgress{after: 4}
+	downloader := NewDownloader(options, progress)
+	progress.downloader = downloader
+
+	// the chunks that are running when the download is paused are completed,
+	// so everything that has been received is kept
+	var completed, completedBytes int64
+	for run := 1; run <= 2; run++ {
+		// the paused downloader keeps the completed chunks without the state file
+		if run == 2 {
+			os.Remove(StatePath(path))
+		}
+		atomic.StoreInt64(&served, 0)
+		if err := downloader.DownloadFile(context.Background(), server.URL, path); err != ErrPaused {
+			t.Fatalf("run %v of the paused download returned %v", run, err)
+		}
+		if progress.resumed != completed {
+			t.Errorf("run %v of the paused download resumed %v chunks, want %v", run, progress.resumed, completed)
+		}
+		if progress.completed < progress.after || progress.completed > progress.after+options.Workers-1 {
+			t.Errorf("run %v of the paused download completed %v chunks after being paused at %v", run, progress.completed, progress.after)
+		}
+		if received := atomic.LoadInt64(&served); received != progress.bytes {
+			t.Errorf("run %v of the paused download received %v bytes for %v bytes of chunks", run, received, progress.bytes)
+		}
+		if _, err := os.Stat(StatePath(path)); err != nil {
+			t.Fatalf("the paused download didn't leave its state behind: %v", err)
+		}
+		completed += int64(progress.completed)
+		completedBytes += progress.bytes
+	}
+
+	// a new downloader continues from the state file
+	atomic.StoreInt64(&served, 0)
+	if err := NewDownloader(options, nil).DownloadFile(context.Background(), server.URL, path); err != nil {
+		t.Fatal(err)
+	}
+	downloaded, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, data) {
+		t.Error("the resumed download doesn't match the file on the server")
+	}
+	// the chunks that are complete aren't fetched again
+	if received, want := atomic.LoadInt64(&served), int64(len(data))-completedBytes; received != want {
+		t.Errorf("the resumed download received %v bytes, want %v", received, want)
+	}
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+	served *int64
+}
+
+func (writer countingResponseWriter) Write(bytes []byte) (int, error) {
+	count, err := writer.ResponseWriter.Write(bytes)
+	atomic.AddInt64(writer.served, int64(count))
+	return count, err
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd
+
+package paralload
+
+// extended attributes are not used on this system, downloaded files are
+// only compared by their size and modification time
+func readETag(path string) string {
+	return ""
+}
+
+func writeETag(path string, etag string) {}
@@ -0,0 +1,23 @@
+//go:build linux || darwin || freebsd
+
+package paralload
+
+import "golang.org/x/sys/unix"
+
+// etagAttribute is the extended attribute the ETag of a file is kept in
+const etagAttribute = "user.paralload.etag"
+
+func readETag(path string) string {
+	etag := make([]byte, 1024)
+	size, err := unix.Getxattr(path, etagAttribute, etag)
+	if err != nil {
+		return ""
+	}
+	return string(etag[:size])
+}
+
+// writeETag ignores file systems without extended attributes, the
+// modification time is compared for them instead
+func writeETag(path string, etag string) {
+	unix.Setxattr(path, etagAttribute, []byte(etag), 0)
+}
@@ -0,0 +1,113 @@
+package paralload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ExistsPolicy decides what happens when the output file already exists
+type ExistsPolicy string
+
+const (
+	// ExistsFail refuses to download the file
+	ExistsFail ExistsPolicy = "fail"
+	// ExistsOverwrite replaces the file once the download has finished
+	ExistsOverwrite ExistsPolicy = "overwrite"
+	// ExistsRename downloads to the first free path of the form "name (1).ext"
+	ExistsRename ExistsPolicy = "rename"
+	// ExistsResume treats the file as the start of the download, this needs
+	// Options.Continue to be set
+	ExistsResume ExistsPolicy = "resume"
+	// ExistsSkipIfSame skips the download if the file has the same size and
+	// ETag or Last-Modified as the file on the server, and overwrites it otherwise
+	ExistsSkipIfSame ExistsPolicy = "skip-if-same"
+)
+
+// ExistsPolicies lists every policy, in the order they are offered to users
+var ExistsPolicies = []ExistsPolicy{ExistsFail, ExistsOverwrite, ExistsRename, ExistsResume, ExistsSkipIfSame}
+
+// ErrUpToDate is returned by ResolveExisting if the file doesn't have to be downloaded
+var ErrUpToDate = errors.New("the file is already up to date")
+
+type ExistsError struct {
+	Path string
+}
+
+func (existsError *ExistsError) Error() string {
+	return fmt.Sprintf("%v already exists", existsError.Path)
+}
+
+func ParseExistsPolicy(value string) (ExistsPolicy, error) {
+	for _, policy := range ExistsPolicies {
+		if string(policy) == value {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("%q is not a valid policy (fail, overwrite, rename, resume or skip-if-same)", value)
+}
+
+// ResolveExisting applies policy if a file already exists at path and
+// returns the path the file should be downloaded to. ExistsResume moves the
+// file to PartPath(path) so that DownloadFile continues it.
+func (downloader *Downloader) ResolveExisting(ctx context.Context, url string, path string, policy ExistsPolicy) (string, error) {
+	fileInfo, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return path, nil
+	} else if err != nil {
+		return "", err
+	}
+
+	switch policy {
+	case ExistsOverwrite:
+		return path, nil
+	case ExistsRename:
+		return UniquePath(path), nil
+	case ExistsResume:
+		// a part file already continues the download where it has stopped
+		if _, err := os.Stat(PartPath(path)); err == nil {
+			return path, nil
+		}
+		return path, os.Rename(path, PartPath(path))
+	case ExistsSkipIfSame:
+		info, err := downloader.Probe(ctx, url)
+		if err != nil {
+			return "", err
+		}
+		if isSameFile(path, fileInfo, info) {
+			return "", ErrUpToDate
+		}
+		return path, nil
+	}
+	return "", &ExistsError{path}
+}
+
+// isSameFile compares a downloaded file to the file on the server, the ETag is
+// preferred if both are known because the modification time can be changed
+func isSameFile(path string, fileInfo os.FileInfo, info *Info) bool {
+	if info.ContentLength == -1 || fileInfo.Size() != info.ContentLength {
+		return false
+	}
+	if etag := readETag(path); etag != "" && info.ETag != "" {
+		return etag == info.ETag
+	}
+	lastModified, err := http.ParseTime(info.LastModified)
+	if err != nil {
+		return false
+	}
+	return fileInfo.ModTime().Truncate(time.Second).Equal(lastModified)
+}
+
+// keepValidators stores the ETag and Last-Modified of the server with a
+// downloaded file, so that a later download can tell whether it has changed
+func keepValidators(path string, info *Info) {
+	if lastModified, err := http.ParseTime(info.LastModified); err == nil {
+		os.Chtimes(path, time.Now(), lastModified)
+	}
+	if info.ETag != "" {
+		writeETag(path, info.ETag)
+	}
+}
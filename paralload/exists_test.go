@@ -0,0 +1,97 @@
+package paralload
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResolveExisting(t *testing.T) {
+	data := []byte("the file on the server")
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("ETag", `"server"`)
+		http.ServeContent(writer, request, "file", modified, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		policy ExistsPolicy
+		// existing is the content of the file at the path (none if nil), part
+		// the content of its part file
+		existing []byte
+		part     []byte
+		modified time.Time
+		etag     string
+		// path is the name that should be returned, partFile the content
+		// the part file should have afterwards
+		path     string
+		err      error
+		partFile []byte
+	}{
+		{name: "no file", policy: ExistsFail, path: "file"},
+		{name: "fail", policy: ExistsFail, existing: []byte("mine"), err: &ExistsError{}},
+		{name: "overwrite", policy: ExistsOverwrite, existing: []byte("mine"), path: "file"},
+		{name: "rename", policy: ExistsRename, existing: []byte("mine"), path: "file (1)"},
+		{name: "resume", policy: ExistsResume, existing: []byte("mine"), path: "file", partFile: []byte("mine")},
+		{name: "resume with a part file", policy: ExistsResume, existing: []byte("mine"), part: []byte("part"), path: "file", partFile: []byte("part")},
+		{name: "same size and time", policy: ExistsSkipIfSame, existing: make([]byte, len(data)), modified: modified, err: ErrUpToDate},
+		{name: "other size", policy: ExistsSkipIfSame, existing: []byte("mine"), modified: modified, path: "file"},
+		{name: "other time", policy: ExistsSkipIfSame, existing: make([]byte, len(data)), modified: modified.Add(time.Hour), path: "file"},
+		{name: "same ETag", policy: ExistsSkipIfSame, existing: make([]byte, len(data)), modified: modified.Add(time.Hour), etag: `"server"`, err: ErrUpToDate},
+		{name: "other ETag", policy: ExistsSkipIfSame, existing: make([]byte, len(data)), modified: modified, etag: `"old"`, path: "file"},
+	}
+	downloader := NewDownloader(Options{}, nil)
+	for _, test := range tests {
+		directory := t.TempDir()
+		path := filepath.Join(directory, "file")
+		if test.existing != nil {
+			if err := os.WriteFile(path, test.existing, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if !test.modified.IsZero() {
+				os.Chtimes(path, test.modified, test.modified)
+			}
+			if test.etag != "" {
+				writeETag(path, test.etag)
+				if readETag(path) != test.etag {
+					t.Logf("%v: skipped, extended attributes are not supported", test.name)
+					continue
+				}
+			}
+		}
+		if test.part != nil {
+			if err := os.WriteFile(PartPath(path), test.part, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		resolved, err := downloader.ResolveExisting(context.Background(), server.URL, path, test.policy)
+		var existsError *ExistsError
+		if test.err != nil {
+			if !errors.Is(err, test.err) && !(errors.As(test.err, &existsError) && errors.As(err, &existsError)) {
+				t.Errorf("%v: ResolveExisting returned %v, want %v", test.name, err, test.err)
+			}
+		} else if err != nil || resolved != filepath.Join(directory, test.path) {
+			t.Errorf("%v: ResolveExisting returned %q, %v, want %q", test.name, filepath.Base(resolved), err, test.path)
+		}
+
+		// the user's file is never lost, at most it is moved to the part file
+		existing, _ := os.ReadFile(path)
+		partFile, _ := os.ReadFile(PartPath(path))
+		if test.partFile != nil {
+			if !bytes.Equal(partFile, test.partFile) {
+				t.Errorf("%v: the part file contains %q, want %q", test.name, partFile, test.partFile)
+			}
+		} else if !bytes.Equal(existing, test.existing) {
+			t.Errorf("%v: the file contains %q, want %q", test.name, existing, test.existing)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package paralload
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
+
+// PartPath returns the path a file is written to until it is complete
+func PartPath(path string) string {
+	return path + ".part"
+}
+
+// DownloadFile downloads url into PartPath(path), which is synced, cut to
+// the size of the file and verified before it is renamed to path. Other
+// programs never see an incomplete file at path, and an interrupted download
+// leaves the part file behind so that it can be resumed.
+func (downloader *Downloader) DownloadFile(ctx context.Context, url string, path string) error {
+	partPath := PartPath(path)
+	// the state of a download is worthless without its part file
+	if _, err := os.Stat(partPath); os.IsNotExist(err) {
+		downloader.removeState()
+	}
+	file, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := downloader.download(ctx, url, file)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if err != nil {
+		return err
+	}
+	if info.ContentLength != -1 {
+		err = file.Truncate(info.ContentLength)
+		if err != nil {
+			return err
+		}
+	}
+	downloader.removeState()
+	err = downloader.verify(ctx, info, file)
+	if err != nil {
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	keepValidators(partPath, info)
+	err = os.Rename(partPath, path)
+	if err != nil {
+		return err
+	}
+	syncDirectory(filepath.Dir(path))
+	return nil
+}
+
+// syncDirectory makes a rename in the directory durable where that is
+// supported, failures are ignored since the file itself has been synced
+func syncDirectory(path string) {
+	directory, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	directory.Sync()
+	directory.Close()
+}
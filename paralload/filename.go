@@ -0,0 +1,106 @@
+package paralload
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultFileName is used when neither the server nor the URL provide a name
+const DefaultFileName = "download"
+
+// maxFileNameLength is the longest name (in bytes) most file systems accept
+const maxFileNameLength = 255
+
+// reservedFileNames can't be used as file names on Windows, with or without an extension
+var reservedFileNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// responseFileName returns the name from the Content-Disposition header of
+// the response, or the last segment of the URL the response came from
+// (after following redirects)
+func responseFileName(response *http.Response) string {
+	_, params, err := mime.ParseMediaType(response.Header.Get("Content-Disposition"))
+	if err == nil && params["filename"] != "" {
+		return SanitizeFileName(params["filename"])
+	}
+	if response.Request != nil && response.Request.URL != nil {
+		return SanitizeFileName(path.Base(response.Request.URL.Path))
+	}
+	return DefaultFileName
+}
+
+// SanitizeFileName turns a name chosen by a server into one that is safe to
+// use on every platform. Directories are removed, characters that aren't
+// allowed on Windows are replaced and DefaultFileName is returned if nothing
+// is left.
+func SanitizeFileName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = name[strings.LastIndex(name, "/")+1:]
+	name = strings.Map(func(letter rune) rune {
+		if unicode.IsControl(letter) || strings.ContainsRune(`<>:"|?*`, letter) {
+			return '_'
+		}
+		return letter
+	}, name)
+	// Windows drops trailing dots and spaces, leading dots hide files elsewhere
+	name = strings.Trim(name, ". ")
+	if base := strings.ToUpper(strings.SplitN(name, ".", 2)[0]); reservedFileNames[base] {
+		name = "_" + name
+	}
+	if len(name) > maxFileNameLength {
+		extension := filepath.Ext(name)
+		if len(extension) > maxFileNameLength/2 {
+			extension = ""
+		}
+		stem := strings.TrimSuffix(name, extension)
+		for len(stem)+len(extension) > maxFileNameLength {
+			_, size := utf8.DecodeLastRuneInString(stem)
+			stem = stem[:len(stem)-size]
+		}
+		name = stem + extension
+	}
+	if name == "" {
+		return DefaultFileName
+	}
+	return name
+}
+
+// UniquePath returns path, or if a file already exists there the first free
+// path of the form "name (1).ext"
+func UniquePath(path string) string {
+	return UniquePathFunc(path, func(candidate string) bool {
+		_, err := os.Lstat(candidate)
+		return !os.IsNotExist(err)
+	})
+}
+
+// UniquePathFunc returns path, or if taken reports it as taken the first
+// path of the form "name (1).ext" that isn't
+func UniquePathFunc(path string, taken func(path string) bool) string {
+	if !taken(path) {
+		return path
+	}
+	directory, name := filepath.Split(path)
+	extension := filepath.Ext(name)
+	// keep double extensions such as .tar.gz together
+	if stem := strings.TrimSuffix(name, extension); strings.EqualFold(filepath.Ext(stem), ".tar") {
+		extension = stem[len(stem)-len(".tar"):] + extension
+	}
+	stem := strings.TrimSuffix(name, extension)
+	for number := 1; ; number++ {
+		candidate := filepath.Join(directory, fmt.Sprintf("%v (%v)%v", stem, number, extension))
+		if !taken(candidate) {
+			return candidate
+		}
+	}
+}
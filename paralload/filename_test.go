@@ -0,0 +1,59 @@
+package paralload
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		name      string
+		sanitized string
+	}{
+		{"file.iso", "file.iso"},
+		{"../../etc/passwd", "passwd"},
+		{`C:\Windows\system.ini`, "system.ini"},
+		{"a<b>c:d\"e|f?g*h.txt", "a_b_c_d_e_f_g_h.txt"},
+		{"line\nbreak.txt", "line_break.txt"},
+		{".hidden", "hidden"},
+		{"trailing. . ", "trailing"},
+		{"CON", "_CON"},
+		{"nul.txt", "_nul.txt"},
+		{"console.txt", "console.txt"},
+		{"", DefaultFileName},
+		{"..", DefaultFileName},
+		{"dir/", DefaultFileName},
+		{strings.Repeat("a", 300) + ".tar", strings.Repeat("a", 251) + ".tar"},
+		{strings.Repeat("é", 200), strings.Repeat("é", 127)},
+	}
+	for _, test := range tests {
+		if sanitized := SanitizeFileName(test.name); sanitized != test.sanitized {
+			t.Errorf("SanitizeFileName(%q) = %q, want %q", test.name, sanitized, test.sanitized)
+		}
+	}
+}
+
+func TestUniquePath(t *testing.T) {
+	directory := t.TempDir()
+	for _, name := range []string{"file.iso", "file (1).iso", "archive.tar.gz", "noextension"} {
+		if err := os.WriteFile(filepath.Join(directory, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name   string
+		unique string
+	}{
+		{"free.iso", "free.iso"},
+		{"file.iso", "file (2).iso"},
+		{"archive.tar.gz", "archive (1).tar.gz"},
+		{"noextension", "noextension (1)"},
+	}
+	for _, test := range tests {
+		if unique := UniquePath(filepath.Join(directory, test.name)); unique != filepath.Join(directory, test.unique) {
+			t.Errorf("UniquePath(%q) = %q, want %q", test.name, filepath.Base(unique), test.unique)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package paralload
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// InputFileEntry is a file of an aria2 style input file, which lists the
+// URLs of every file on one line (mirrors separated by tabs) followed by
+// indented options such as "  out=file.iso"
+type InputFileEntry struct {
+	URLs []string
+	// Out is the name of the output file, Dir the directory it is stored in
+	Out      string
+	Dir      string
+	Checksum *Checksum
+	Headers  http.Header
+	// Line is the line of the input file the entry starts on
+	Line int
+}
+
+// ParseInputFile parses an input file, lines starting with # are comments.
+// The supported options are out, dir, checksum (sha-256=<digest> as in aria2
+// or sha256:<digest>) and header, which can be given several times.
+func ParseInputFile(reader io.Reader) ([]*InputFileEntry, error) {
+	var entries []*InputFileEntry
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		trimmedLine := strings.TrimSpace(line)
+		if trimmedLine == "" || strings.HasPrefix(trimmedLine, "#") {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			entries = append(entries, &InputFileEntry{URLs: strings.Fields(line), Headers: make(http.Header), Line: lineNumber})
+			continue
+		}
+		if len(entries) == 0 {
+			return nil, fmt.Errorf("line %v: options have to follow a URL", lineNumber)
+		}
+		err := entries[len(entries)-1].setOption(trimmedLine)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", lineNumber, err)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+func (entry *InputFileEntry) setOption(option string) error {
+	name, value, found := strings.Cut(option, "=")
+	if !found {
+		return fmt.Errorf("%q must be in the format <option>=<value>", option)
+	}
+	switch strings.TrimSpace(name) {
+	case "out":
+		entry.Out = value
+	case "dir":
+		entry.Dir = value
+	case "checksum":
+		if hashType, digest, found := strings.Cut(value, "="); found {
+			algorithm := metalinkAlgorithm(hashType)
+			if algorithm == "" {
+				return fmt.Errorf("the checksum type %q is not supported", hashType)
+			}
+			value = algorithm + ":" + digest
+		}
+		checksum, err := ParseChecksum(value)
+		if err != nil {
+			return err
+		}
+		entry.Checksum = checksum
+	case "header":
+		headerName, headerValue, found := strings.Cut(value, ":")
+		if !found {
+			return fmt.Errorf("%q must be in the format <name>: <value>", value)
+		}
+		entry.Headers.Add(textproto.TrimString(headerName), textproto.TrimString(headerValue))
+	default:
+		return fmt.Errorf("the option %q is not supported", name)
+	}
+	return nil
+}
+
+// FileName returns the sanitized name of the output file, or an empty
+// string if it should be taken from the server
+func (entry *InputFileEntry) FileName() string {
+	if entry.Out == "" {
+		return ""
+	}
+	return SanitizeFileName(entry.Out)
+}
+
+// Apply returns the URL to download the file from and fills in the mirrors,
+// the checksum and the headers of the options
+func (entry *InputFileEntry) Apply(options *Options) string {
+	options.Mirrors = entry.URLs[1:]
+	if entry.Checksum != nil {
+		options.Checksum = entry.Checksum
+	}
+	headers := options.Headers.Clone()
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	for name, values := range entry.Headers {
+		headers[name] = append(headers[name], values...)
+	}
+	options.Headers = headers
+	return entry.URLs[0]
+}
@@ -0,0 +1,95 @@
+package paralload
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseInputFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		entries []*InputFileEntry
+		err     string
+	}{
+		{
+			name:  "urls and mirrors",
+			input: "# comment\nhttp://a/1.iso\thttp://b/1.iso\n\nhttp://a/2.iso\r\n",
+			entries: []*InputFileEntry{
+				{URLs: []string{"http://a/1.iso", "http://b/1.iso"}, Headers: http.Header{}, Line: 2},
+				{URLs: []string{"http://a/2.iso"}, Headers: http.Header{}, Line: 4},
+			},
+		},
+		{
+			name:  "options",
+			input: "http://a/1.iso\n  out=one.iso\n\tdir=images\n  header=Authorization: Bearer token\n  header=X-Test:1\n",
+			entries: []*InputFileEntry{{
+				URLs:    []string{"http://a/1.iso"},
+				Out:     "one.iso",
+				Dir:     "images",
+				Headers: http.Header{"Authorization": {"Bearer token"}, "X-Test": {"1"}},
+				Line:    1,
+			}},
+		},
+		{
+			name:  "aria2 checksum",
+			input: "http://a/1.iso\n  checksum=sha-256=ABCDEF\n",
+			entries: []*InputFileEntry{{
+				URLs:     []string{"http://a/1.iso"},
+				Checksum: &Checksum{Algorithm: "sha256", Expected: "abcdef"},
+				Headers:  http.Header{},
+				Line:     1,
+			}},
+		},
+		{
+			name:  "paralload checksum",
+			input: "http://a/1.iso\n  checksum=md5:0123\n",
+			entries: []*InputFileEntry{{
+				URLs:     []string{"http://a/1.iso"},
+				Checksum: &Checksum{Algorithm: "md5", Expected: "0123"},
+				Headers:  http.Header{},
+				Line:     1,
+			}},
+		},
+		{name: "option without url", input: "  out=one.iso\n", err: "line 1: options have to follow a URL"},
+		{name: "unknown option", input: "http://a/1.iso\n  split=4\n", err: `line 2: the option "split" is not supported`},
+		{name: "option without value", input: "http://a/1.iso\n  out\n", err: `line 2: "out" must be in the format <option>=<value>`},
+		{name: "unsupported checksum", input: "http://a/1.iso\n  checksum=crc32=1234\n", err: `line 2: the checksum type "crc32" is not supported`},
+		{name: "invalid header", input: "http://a/1.iso\n  header=token\n", err: `line 2: "token" must be in the format <name>: <value>`},
+	}
+	for _, test := range tests {
+		entries, err := ParseInputFile(strings.NewReader(test.input))
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%v: got the error %v, want %v", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(entries, test.entries) {
+			t.Errorf("%v: got %+v, want %+v", test.name, entries, test.entries)
+		}
+	}
+}
+
+func TestInputFileEntryApply(t *testing.T) {
+	entry := &InputFileEntry{
+		URLs:    []string{"http://a/1.iso", "http://b/1.iso"},
+		Headers: http.Header{"X-Entry": {"1"}},
+	}
+	options := Options{Headers: http.Header{"X-Global": {"1"}}}
+	if url := entry.Apply(&options); url != "http://a/1.iso" {
+		t.Errorf("Apply returned %v", url)
+	}
+	if !reflect.DeepEqual(options.Mirrors, []string{"http://b/1.iso"}) {
+		t.Errorf("the mirrors are %v", options.Mirrors)
+	}
+	if options.Headers.Get("X-Global") != "1" || options.Headers.Get("X-Entry") != "1" {
+		t.Errorf("the headers are %v", options.Headers)
+	}
+}
@@ -0,0 +1,178 @@
+package paralload
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Metalink is a parsed RFC 5854 (.meta4) document
+type Metalink struct {
+	XMLName xml.Name       `xml:"urn:ietf:params:xml:ns:metalink metalink"`
+	Files   []MetalinkFile `xml:"file"`
+}
+
+type MetalinkFile struct {
+	Name   string          `xml:"name,attr"`
+	Size   int64           `xml:"size"`
+	URLs   []MetalinkURL   `xml:"url"`
+	Hashes []MetalinkHash  `xml:"hash"`
+	Pieces *MetalinkPieces `xml:"pieces"`
+}
+
+type MetalinkURL struct {
+	Priority int    `xml:"priority,attr"`
+	Location string `xml:"location,attr"`
+	URL      string `xml:",chardata"`
+}
+
+type MetalinkHash struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type MetalinkPieces struct {
+	Length int64    `xml:"length,attr"`
+	Type   string   `xml:"type,attr"`
+	Hashes []string `xml:"hash"`
+}
+
+// Pieces are verified as soon as they have been downloaded, a piece with
+// the wrong hash is downloaded again (preferably from another mirror)
+type Pieces struct {
+	Algorithm string
+	Length    int64
+	Hashes    []string
+}
+
+// metalink hash types are IANA names, the strongest supported one is used
+var metalinkHashTypes = []struct{ metalink, algorithm string }{
+	{"sha-512", "sha512"},
+	{"sha-256", "sha256"},
+	{"sha-1", "sha1"},
+	{"md5", "md5"},
+}
+
+func metalinkAlgorithm(hashType string) string {
+	for _, hashTypes := range metalinkHashTypes {
+		if strings.EqualFold(hashTypes.metalink, hashType) {
+			return hashTypes.algorithm
+		}
+	}
+	return ""
+}
+
+func ParseMetalink(reader io.Reader) (*Metalink, error) {
+	var metalink Metalink
+	err := xml.NewDecoder(reader).Decode(&metalink)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metalink: %w", err)
+	}
+	if len(metalink.Files) == 0 {
+		return nil, errors.New("the metalink does not describe any files")
+	}
+	for index := range metalink.Files {
+		file := &metalink.Files[index]
+		file.Name = strings.TrimSpace(file.Name)
+		if file.Name == "" {
+			return nil, errors.New("the metalink contains a file without a name")
+		}
+		for urlIndex := range file.URLs {
+			file.URLs[urlIndex].URL = strings.TrimSpace(file.URLs[urlIndex].URL)
+		}
+		for hashIndex := range file.Hashes {
+			file.Hashes[hashIndex].Value = strings.TrimSpace(file.Hashes[hashIndex].Value)
+		}
+		if file.Pieces != nil {
+			for hashIndex := range file.Pieces.Hashes {
+				file.Pieces.Hashes[hashIndex] = strings.TrimSpace(file.Pieces.Hashes[hashIndex])
+			}
+		}
+	}
+	return &metalink, nil
+}
+
+// FetchMetalink downloads and parses the metalink at url
+func (downloader *Downloader) FetchMetalink(ctx context.Context, url string) (*Metalink, error) {
+	request, err := downloader.newRequest(ctx, "GET", url)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/metalink4+xml")
+	response, err := downloader.newClient(downloader.options.Timeout).Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch %v: %v", url, response.Status)
+	}
+	return ParseMetalink(response.Body)
+}
+
+// FileName returns the name of the file without any directories, metalinks
+// may contain paths but they must not escape the download directory
+func (file *MetalinkFile) FileName() string {
+	return SanitizeFileName(file.Name)
+}
+
+// checksum returns the strongest supported whole file hash, if there is one
+func (file *MetalinkFile) checksum() (*Checksum, error) {
+	for _, hashTypes := range metalinkHashTypes {
+		for _, hash := range file.Hashes {
+			if strings.EqualFold(hash.Type, hashTypes.metalink) {
+				return ParseChecksum(hashTypes.algorithm + ":" + hash.Value)
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Apply returns the URL to download the file from and fills in the mirrors,
+// the piece hashes and the checksum (if the metalink has one) of the options
+func (file *MetalinkFile) Apply(options *Options) (string, error) {
+	urls := make([]MetalinkURL, 0, len(file.URLs))
+	for _, url := range file.URLs {
+		if strings.HasPrefix(url.URL, "http://") || strings.HasPrefix(url.URL, "https://") {
+			urls = append(urls, url)
+		}
+	}
+	if len(urls) == 0 {
+		return "", fmt.Errorf("the metalink does not contain any HTTP(S) URLs for %v", file.Name)
+	}
+	// a lower priority value is preferred, URLs without a priority come last
+	sort.SliceStable(urls, func(i, j int) bool {
+		if urls[i].Priority == 0 || urls[j].Priority == 0 {
+			return urls[j].Priority == 0 && urls[i].Priority != 0
+		}
+		return urls[i].Priority < urls[j].Priority
+	})
+	options.Mirrors = nil
+	for _, url := range urls[1:] {
+		options.Mirrors = append(options.Mirrors, url.URL)
+	}
+
+	checksum, err := file.checksum()
+	if err != nil {
+		return "", err
+	}
+	if checksum != nil {
+		options.Checksum = checksum
+	}
+
+	options.Pieces = nil
+	if file.Pieces != nil && file.Pieces.Length > 0 {
+		algorithm := metalinkAlgorithm(file.Pieces.Type)
+		if algorithm == "" {
+			return "", fmt.Errorf("unsupported piece hash type: %v", file.Pieces.Type)
+		}
+		options.Pieces = &Pieces{algorithm, file.Pieces.Length, file.Pieces.Hashes}
+	}
+	options.ExpectedSize = file.Size
+	return urls[0].URL, nil
+}
@@ -0,0 +1,102 @@
+package paralload
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testMetalink = `<?xml version="1.0" encoding="UTF-8"?>
+<metalink xmlns="urn:ietf:params:xml:ns:metalink">
+  <file name=" example.iso ">
+    <size>1048576</size>
+    <hash type="sha-1">da39a3ee5e6b4b0d3255bfef95601890afd80709</hash>
+    <hash type="sha-256">
+      e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855
+    </hash>
+    <pieces length="524288" type="sha-1">
+      <hash>aaaa</hash>
+      <hash> bbbb </hash>
+    </pieces>
+    <url>http://none.example.com/example.iso</url>
+    <url priority="2">https://second.example.com/example.iso</url>
+    <url priority="1" location="de"> https://first.example.com/example.iso </url>
+    <url priority="1">ftp://ftp.example.com/example.iso</url>
+    <url priority="3">http://third.example.com/example.iso</url>
+  </file>
+</metalink>`
+
+func TestParseMetalink(t *testing.T) {
+	metalink, err := ParseMetalink(strings.NewReader(testMetalink))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := metalink.Files[0]
+	if file.Name != "example.iso" || file.Size != 1048576 || len(file.URLs) != 5 {
+		t.Errorf("parsed %+v", file)
+	}
+	if file.URLs[2].URL != "https://first.example.com/example.iso" || file.URLs[2].Location != "de" {
+		t.Errorf("parsed the URL %+v", file.URLs[2])
+	}
+	if !reflect.DeepEqual(file.Pieces.Hashes, []string{"aaaa", "bbbb"}) {
+		t.Errorf("parsed the pieces %v", file.Pieces.Hashes)
+	}
+
+	invalid := []struct {
+		name     string
+		metalink string
+	}{
+		{"not xml", "metalink"},
+		{"wrong namespace", `<metalink xmlns="urn:example"><file name="a"/></metalink>`},
+		{"no files", `<metalink xmlns="urn:ietf:params:xml:ns:metalink"></metalink>`},
+		{"file without a name", `<metalink xmlns="urn:ietf:params:xml:ns:metalink"><file name=" "/></metalink>`},
+	}
+	for _, test := range invalid {
+		if _, err := ParseMetalink(strings.NewReader(test.metalink)); err == nil {
+			t.Errorf("%v: ParseMetalink didn't return an error", test.name)
+		}
+	}
+}
+
+func TestMetalinkFileApply(t *testing.T) {
+	metalink, err := ParseMetalink(strings.NewReader(testMetalink))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var options Options
+	url, err := metalink.Files[0].Apply(&options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sorted by priority, URLs without one come last and FTP is left out
+	if url != "https://first.example.com/example.iso" {
+		t.Errorf("Apply returned %v", url)
+	}
+	mirrors := []string{"https://second.example.com/example.iso", "http://third.example.com/example.iso", "http://none.example.com/example.iso"}
+	if !reflect.DeepEqual(options.Mirrors, mirrors) {
+		t.Errorf("the mirrors are %v, want %v", options.Mirrors, mirrors)
+	}
+	if options.Checksum == nil || options.Checksum.Algorithm != "sha256" {
+		t.Errorf("the checksum is %v, want the sha256 one", options.Checksum)
+	}
+	if options.Pieces == nil || options.Pieces.Algorithm != "sha1" || options.Pieces.Length != 524288 {
+		t.Errorf("the pieces are %+v", options.Pieces)
+	}
+	if options.ExpectedSize != 1048576 {
+		t.Errorf("the expected size is %v", options.ExpectedSize)
+	}
+
+	tests := []struct {
+		name string
+		file MetalinkFile
+	}{
+		{"no HTTP URLs", MetalinkFile{Name: "a", URLs: []MetalinkURL{{URL: "ftp://example.com/a"}}}},
+		{"unsupported piece hash", MetalinkFile{Name: "a", URLs: []MetalinkURL{{URL: "http://example.com/a"}}, Pieces: &MetalinkPieces{Length: 1, Type: "crc32"}}},
+		{"invalid hash", MetalinkFile{Name: "a", URLs: []MetalinkURL{{URL: "http://example.com/a"}}, Hashes: []MetalinkHash{{"sha-256", "xyz"}}}},
+	}
+	for _, test := range tests {
+		if _, err := test.file.Apply(&Options{}); err == nil {
+			t.Errorf("%v: Apply didn't return an error", test.name)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package paralload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// a mirror is disabled after this many failed requests in a row,
+// unless it is the last mirror that is still enabled
+const maxMirrorErrors = 3
+
+var ErrNoMirrors = errors.New("all mirrors have failed")
+
+type mirror struct {
+	url string
+	// speed is the average speed of the last chunks in bytes per second,
+	// it is 0 until the first chunk has been downloaded from the mirror
+	speed    float64
+	errors   int
+	disabled bool
+}
+
+type mirrorSet struct {
+	mirrors []*mirror
+	mutex   sync.Mutex
+}
+
+// probeMirrors checks that every mirror serves the same file as info,
+// mirrors that don't are reported and left out
+func (downloader *Downloader) probeMirrors(ctx context.Context, info *Info) *mirrorSet {
+	mirrorSet := &mirrorSet{mirrors: []*mirror{{url: info.URL}}}
+	info.Mirrors = []string{info.URL}
+	if !info.AcceptsRanges {
+		return mirrorSet
+	}
+
+	errs := make([]error, len(downloader.options.Mirrors))
+	var waitGroup sync.WaitGroup
+	for index, url := range downloader.options.Mirrors {
+		waitGroup.Add(1)
+		go func(index int, url string) {
+			defer waitGroup.Done()
+			mirrorInfo, err := downloader.Probe(ctx, url)
+			if err != nil {
+				errs[index] = err
+			} else if !mirrorInfo.AcceptsRanges {
+				errs[index] = errors.New("the mirror does not support HTTP byte ranges")
+			} else if mirrorInfo.ContentLength != info.ContentLength {
+				errs[index] = fmt.Errorf("the mirror has a different Content-Length (%v instead of %v)", mirrorInfo.ContentLength, info.ContentLength)
+			} else if mirrorInfo.ETag != "" && info.ETag != "" && mirrorInfo.ETag != info.ETag {
+				errs[index] = fmt.Errorf("the mirror has a different ETag (%v instead of %v)", mirrorInfo.ETag, info.ETag)
+			}
+		}(index, url)
+	}
+	waitGroup.Wait()
+
+	for index, url := range downloader.options.Mirrors {
+		if errs[index] != nil {
+			if mirrorProgress, ok := downloader.progress.(MirrorProgress); ok {
+				mirrorProgress.MirrorFailed(url, errs[index])
+			}
+			continue
+		}
+		mirrorSet.mirrors = append(mirrorSet.mirrors, &mirror{url: url})
+		info.Mirrors = append(info.Mirrors, url)
+	}
+	return mirrorSet
+}
+
+// pick chooses a mirror with a probability proportional to its speed, so
+// faster mirrors receive more chunks. Mirrors that have not been measured
+// yet are treated like the fastest one, and exclude (the mirror that just
+// failed the chunk) is only used if there is no other choice.
+func (mirrorSet *mirrorSet) pick(exclude *mirror) *mirror {
+	mirrorSet.mutex.Lock()
+	defer mirrorSet.mutex.Unlock()
+
+	var candidates []*mirror
+	for _, mirror := range mirrorSet.mirrors {
+		if !mirror.disabled && mirror != exclude {
+			candidates = append(candidates, mirror)
+		}
+	}
+	if len(candidates) == 0 {
+		if exclude == nil || exclude.disabled {
+			return nil
+		}
+		return exclude
+	}
+
+	fastest := 1.0
+	for _, mirror := range candidates {
+		if mirror.speed > fastest {
+			fastest = mirror.speed
+		}
+	}
+	weights := make([]float64, len(candidates))
+	var total float64
+	for index, mirror := range candidates {
+		weights[index] = mirror.speed
+		if weights[index] == 0 {
+			weights[index] = fastest
+		}
+		total += weights[index]
+	}
+	choice := rand.Float64() * total
+	for index, weight := range weights {
+		if choice < weight {
+			return candidates[index]
+		}
+		choice -= weight
+	}
+	return candidates[len(candidates)-1]
+}
+
+func (mirrorSet *mirrorSet) succeeded(mirror *mirror, bytes int64, duration time.Duration) {
+	mirrorSet.mutex.Lock()
+	defer mirrorSet.mutex.Unlock()
+	mirror.errors = 0
+	speed := float64(bytes) / duration.Seconds()
+	if mirror.speed == 0 {
+		mirror.speed = speed
+	} else {
+		mirror.speed = 0.7*mirror.speed + 0.3*speed
+	}
+}
+
+// failed records an error and returns true if the mirror has been disabled
+func (mirrorSet *mirrorSet) failed(mirror *mirror, permanent bool) bool {
+	mirrorSet.mutex.Lock()
+	defer mirrorSet.mutex.Unlock()
+	mirror.errors++
+	if mirror.disabled || (!permanent && mirror.errors < maxMirrorErrors) {
+		return false
+	}
+	for _, otherMirror := range mirrorSet.mirrors {
+		if otherMirror != mirror && !otherMirror.disabled {
+			mirror.disabled = true
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package paralload
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mirrorProgress records the mirrors that have been left out
+type mirrorProgress struct {
+	noProgress
+	failed []string
+	mutex  sync.Mutex
+}
+
+func (progress *mirrorProgress) MirrorFailed(url string, err error) {
+	progress.mutex.Lock()
+	progress.failed = append(progress.failed, url)
+	progress.mutex.Unlock()
+}
+
+func TestMirrors(t *testing.T) {
+	data := make([]byte, 2<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	serve := func(data []byte, failGets bool, gets *int32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if request.Method == "GET" {
+				atomic.AddInt32(gets, 1)
+				if failGets {
+					http.Error(writer, "unavailable", http.StatusInternalServerError)
+					return
+				}
+			}
+			http.ServeContent(writer, request, "file", time.Time{}, bytes.NewReader(data))
+		}))
+	}
+	tests := []struct {
+		name string
+		// data and failGets describe the mirror, the primary always works
+		data     []byte
+		failGets bool
+		// mirrorGets is whether the mirror should be asked for any chunks
+		mirrorGets bool
+	}{
+		{"working mirror", data, false, true},
+		{"failing mirror", data, true, true},
+		{"different size", data[:len(data)-1], false, false},
+	}
+	for _, test := range tests {
+		var primaryGets, mirrorGets int32
+		primary := serve(data, false, &primaryGets)
+		mirror := serve(test.data, test.failGets, &mirrorGets)
+		progress := &mirrorProgress{}
+		output := &memoryFile{}
+		options := Options{Workers: 4, ChunkSize: MinChunkSize, RetryDelay: time.Millisecond, Mirrors: []string{mirror.URL}}
+		err := NewDownloader(options, progress).Download(context.Background(), primary.URL, output)
+		primary.Close()
+		mirror.Close()
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(output.data, data) {
+			t.Errorf("%v: the download doesn't match the file on the server", test.name)
+		}
+		if (mirrorGets > 0) != test.mirrorGets {
+			t.Errorf("%v: the mirror received %v requests for chunks", test.name, mirrorGets)
+		}
+		if left := len(progress.failed) > 0; left != (test.failGets || !test.mirrorGets) {
+			t.Errorf("%v: the mirrors %v have been left out", test.name, strings.Join(progress.failed, ", "))
+		}
+	}
+}
+
+func TestMirrorSet(t *testing.T) {
+	first, second := &mirror{url: "first"}, &mirror{url: "second"}
+	mirrorSet := &mirrorSet{mirrors: []*mirror{first, second}}
+
+	// a chunk that failed on a mirror is retried on the other one
+	for i := 0; i < 20; i++ {
+		if picked := mirrorSet.pick(first); picked != second {
+			t.Fatalf("pick excluding the first mirror returned %v", picked.url)
+		}
+	}
+	for i := 1; i < maxMirrorErrors; i++ {
+		if mirrorSet.failed(first, false) {
+			t.Fatalf("the first mirror has been disabled after %v errors", i)
+		}
+	}
+	if !mirrorSet.failed(first, false) {
+		t.Fatalf("the first mirror is still enabled after %v errors", maxMirrorErrors)
+	}
+	for i := 0; i < 20; i++ {
+		if picked := mirrorSet.pick(nil); picked != second {
+			t.Fatalf("pick returned the disabled mirror")
+		}
+	}
+	// the last mirror is kept, even after permanent errors
+	if mirrorSet.failed(second, true) || second.disabled {
+		t.Error("the last mirror has been disabled")
+	}
+	if picked := mirrorSet.pick(second); picked != second {
+		t.Error("pick didn't fall back to the excluded mirror")
+	}
+}
@@ -0,0 +1,98 @@
+package paralload
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultWorkers    int           = 16
+	DefaultMaxWorkers int           = 64
+	DefaultChunkSize  int64         = 1048576
+	MinChunkSize      int64         = 262144
+	MaxChunkSize      int64         = 67108864
+	DefaultTimeout    time.Duration = 10 * time.Second
+	DefaultUserAgent  string        = "go-http-client/paralload"
+	DefaultRetries    int           = 10
+	DefaultRetryDelay time.Duration = time.Second
+)
+
+// NoRetries is set as Options.MaxRetries to fail a download on the first
+// error of a chunk, since 0 picks DefaultRetries
+const NoRetries = -1
+
+type Options struct {
+	// Workers is the amount of chunks that are downloaded at the same time
+	Workers int
+	// AutoWorkers starts with a few workers and adds more while the throughput
+	// keeps rising, Workers (DefaultMaxWorkers if 0) is the upper limit
+	AutoWorkers bool
+	// ChunkSize is the amount of bytes requested by each HTTP range request,
+	// 0 picks one from the file size, the round-trip time and the workers
+	// (between MinChunkSize and MaxChunkSize)
+	ChunkSize int64
+	// Timeout applies to connecting, the TLS handshake and waiting for response headers
+	Timeout   time.Duration
+	UserAgent string
+	// MaxRetries is the amount of times a chunk is retried before the download
+	// fails, 0 uses DefaultRetries and NoRetries disables retrying
+	MaxRetries int
+	// RetryDelay is the delay before the first retry, it doubles with every retry
+	RetryDelay time.Duration
+	// StatePath is where the list of completed chunks is kept so that an
+	// interrupted download can be resumed, resuming is disabled if it is empty
+	StatePath string
+	// Checksum is verified once the download has finished, this requires
+	// the output to also implement io.ReaderAt
+	Checksum *Checksum
+	// Mirrors are additional URLs of the same file that chunks are spread across
+	Mirrors []string
+	// Pieces are the hashes of every chunk, they override the chunk size
+	// and require the output to also implement io.ReaderAt
+	Pieces *Pieces
+	// ExpectedSize makes the download fail if the server reports another size
+	ExpectedSize int64
+	// Continue keeps the existing data of the output and only downloads the
+	// rest of the file, if there is no saved state to resume from
+	Continue bool
+	// Preallocate reserves the space for the whole file before the download
+	// starts (only on Linux), so that it can't run out of space halfway
+	Preallocate bool
+	// HTTP2 lets HTTP/2 servers multiplex the chunks over a single connection,
+	// by default every worker uses its own HTTP/1.1 connection
+	HTTP2 bool
+	// Headers are sent with every request
+	Headers http.Header
+	// WorkerBudget limits the combined workers of several downloads if it isn't nil
+	WorkerBudget *WorkerBudget
+	// RateLimiter limits the combined speed of all workers if it isn't nil
+	RateLimiter *RateLimiter
+}
+
+func (options Options) withDefaults() Options {
+	if options.Workers < 1 && options.AutoWorkers {
+		options.Workers = DefaultMaxWorkers
+	} else if options.Workers < 1 {
+		options.Workers = DefaultWorkers
+	}
+	if options.Pieces != nil {
+		options.ChunkSize = options.Pieces.Length
+	} else if options.ChunkSize < 0 {
+		options.ChunkSize = 0
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	if options.MaxRetries == 0 {
+		options.MaxRetries = DefaultRetries
+	} else if options.MaxRetries < 0 {
+		options.MaxRetries = 0
+	}
+	if options.RetryDelay <= 0 {
+		options.RetryDelay = DefaultRetryDelay
+	}
+	if options.UserAgent == "" {
+		options.UserAgent = DefaultUserAgent
+	}
+	return options
+}
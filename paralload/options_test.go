@@ -0,0 +1,21 @@
+package paralload
+
+import "testing"
+
+func TestOptionsMaxRetries(t *testing.T) {
+	tests := []struct {
+		maxRetries int
+		want       int
+	}{
+		{0, DefaultRetries},
+		{NoRetries, 0},
+		{-5, 0},
+		{1, 1},
+		{3, 3},
+	}
+	for _, test := range tests {
+		if maxRetries := (Options{MaxRetries: test.maxRetries}).withDefaults().MaxRetries; maxRetries != test.want {
+			t.Errorf("MaxRetries %v became %v, want %v", test.maxRetries, maxRetries, test.want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package paralload
+
+import "errors"
+
+// ErrPaused is returned by a download that has been stopped with Pause
+var ErrPaused = errors.New("the download has been paused")
+
+// Pause stops the running download once the chunks that are being downloaded
+// are complete, no new chunks are started. The completed chunks are kept by
+// the downloader and in the state file (if there is one), so the next
+// Download or DownloadFile of the same file continues where it stopped.
+// Downloads without byte ranges stop right away and start over when resumed.
+func (downloader *Downloader) Pause() {
+	downloader.pauseMutex.Lock()
+	defer downloader.pauseMutex.Unlock()
+	select {
+	case <-downloader.pause:
+	default:
+		close(downloader.pause)
+	}
+}
+
+// pauseSignal returns a channel that is closed once the download is paused
+func (downloader *Downloader) pauseSignal() <-chan struct{} {
+	downloader.pauseMutex.Lock()
+	defer downloader.pauseMutex.Unlock()
+	return downloader.pause
+}
+
+func (downloader *Downloader) paused() bool {
+	select {
+	case <-downloader.pauseSignal():
+		return true
+	default:
+		return false
+	}
+}
+
+// resetPause is called after every download, so that a pause only stops
+// the download it was meant for
+func (downloader *Downloader) resetPause() {
+	downloader.pauseMutex.Lock()
+	defer downloader.pauseMutex.Unlock()
+	select {
+	case <-downloader.pause:
+		downloader.pause = make(chan struct{})
+	default:
+	}
+}
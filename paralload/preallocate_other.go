@@ -0,0 +1,11 @@
+//go:build !linux
+
+package paralload
+
+import "os"
+
+// preallocate is only supported on Linux, other systems rely on the
+// free space check before the download
+func preallocate(file *os.File, size int64) error {
+	return nil
+}
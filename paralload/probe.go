@@ -0,0 +1,105 @@
+package paralload
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"time"
+)
+
+type Info struct {
+	URL string
+	// ContentLength is -1 if the server did not provide it
+	ContentLength int64
+	AcceptsRanges bool
+	ETag          string
+	LastModified  string
+	// FileName is the sanitized name from the Content-Disposition header or
+	// the last segment of the URL after following redirects
+	FileName string
+	// Mirrors lists the URLs the file is downloaded from, starting with URL
+	Mirrors []string
+	// RTT is the time between sending the probe and receiving the first
+	// byte of the response, connecting to the server is not included
+	RTT time.Duration
+}
+
+func (downloader *Downloader) Probe(ctx context.Context, url string) (*Info, error) {
+	client := downloader.newClient(downloader.options.Timeout)
+	request, err := downloader.newRequest(ctx, "HEAD", url)
+	if err != nil {
+		return nil, err
+	}
+	var sent, received time.Time
+	request = request.WithContext(httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteRequest:         func(httptrace.WroteRequestInfo) { sent = time.Now() },
+		GotFirstResponseByte: func() { received = time.Now() },
+	}))
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	response.Body.Close()
+	// some servers don't implement HEAD, the range request below still works for them
+	if response.StatusCode >= 400 && response.StatusCode != http.StatusMethodNotAllowed && response.StatusCode != http.StatusNotImplemented {
+		return nil, newStatusError(response)
+	}
+
+	info := &Info{
+		URL:           url,
+		ContentLength: -1,
+		AcceptsRanges: response.Header.Get("Accept-Ranges") == "bytes",
+		ETag:          response.Header.Get("ETag"),
+		LastModified:  response.Header.Get("Last-Modified"),
+		FileName:      responseFileName(response),
+		Mirrors:       []string{url},
+		RTT:           received.Sub(sent),
+	}
+	contentLength, err := strconv.ParseInt(response.Header.Get("Content-Length"), 10, 64)
+	if err == nil && contentLength >= 0 {
+		info.ContentLength = contentLength
+	}
+	if info.AcceptsRanges && info.ContentLength != -1 {
+		return info, nil
+	}
+
+	// many servers support byte ranges without advertising them
+	request, err = downloader.newRequest(ctx, "GET", url)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Range", "bytes=0-0")
+	response, err = client.Do(request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return info, nil
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 1))
+	// servers that don't implement HEAD may only send the name with the file
+	if response.Header.Get("Content-Disposition") != "" {
+		info.FileName = responseFileName(response)
+	}
+	if response.StatusCode != http.StatusPartialContent {
+		info.AcceptsRanges = false
+		return info, nil
+	}
+	start, end, size, err := parseContentRange(response.Header.Get("Content-Range"))
+	if err != nil || start != 0 || end != 0 || size == -1 {
+		info.AcceptsRanges = false
+		return info, nil
+	}
+	info.AcceptsRanges = true
+	info.ContentLength = size
+	if info.ETag == "" {
+		info.ETag = response.Header.Get("ETag")
+	}
+	if info.LastModified == "" {
+		info.LastModified = response.Header.Get("Last-Modified")
+	}
+	return info, nil
+}
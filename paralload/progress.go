@@ -0,0 +1,74 @@
+package paralload
+
+type Chunk struct {
+	Id     int
+	Offset int64
+	// Length is -1 for single stream downloads of an unknown size
+	Length int64
+	// Mirror is the URL the chunk is currently being downloaded from
+	Mirror string
+	// Retries is the amount of times the chunk has failed so far
+	Retries int
+	// Split is 0 for the original range of a chunk and counts up for the
+	// ranges that were split off it and handed to other workers
+	Split int
+	// Duplicate is true for endgame requests that race another worker for
+	// the rest of a chunk, only the first one to finish is used
+	Duplicate bool
+}
+
+// Progress receives updates about a download, its methods are called from
+// the worker goroutines so implementations have to be safe for concurrent use.
+// The optional interfaces below report further events to implementations
+// that need them.
+type Progress interface {
+	Started(info *Info, chunkCount int64, completedChunks int64)
+	ChunkStarted(chunk *Chunk)
+	ChunkProgress(chunk *Chunk, downloaded int64)
+	ChunkCompleted(chunk *Chunk)
+	// ChunkFailed is called before a failed chunk is retried
+	ChunkFailed(chunk *Chunk, err error)
+	Verifying(checksum *Checksum)
+}
+
+// ResumeProgress can be implemented by a Progress that needs to know which
+// chunks were completed before the download was resumed, ChunksResumed is
+// called with them right after Started
+type ResumeProgress interface {
+	ChunksResumed(chunks []*Chunk)
+}
+
+// SplitProgress can be implemented by a Progress that needs to know when an
+// idle worker takes over the end of a slow chunk, chunk.Length has been
+// shortened and part is started afterwards
+type SplitProgress interface {
+	ChunkSplit(chunk *Chunk, part *Chunk)
+}
+
+// MirrorProgress can be implemented by a Progress that needs to know when a
+// mirror is left out because it does not match the file or keeps failing
+type MirrorProgress interface {
+	MirrorFailed(url string, err error)
+}
+
+// WorkersProgress can be implemented by a Progress that needs to know the
+// amount of workers whenever it is adjusted by Options.AutoWorkers
+type WorkersProgress interface {
+	WorkersChanged(workers int)
+}
+
+// DiskProgress can be implemented by a Progress that needs to know when a
+// worker pauses because the disk is full, it continues by itself once
+// enough space has been freed
+type DiskProgress interface {
+	DiskFull(err *DiskSpaceError)
+}
+
+type noProgress struct{}
+
+func (noProgress) Started(*Info, int64, int64) {}
+func (noProgress) ChunkStarted(*Chunk)         {}
+func (noProgress) ChunkProgress(*Chunk, int64) {}
+func (noProgress) ChunkCompleted(*Chunk)       {}
+func (noProgress) ChunkFailed(*Chunk, error)   {}
+func (noProgress) Verifying(*Checksum)         {}
@@ -0,0 +1,96 @@
+package paralload
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// chunksPerWorker is the amount of chunks every worker should get when the
+// chunk size is picked automatically
+const chunksPerWorker = 4
+
+var ErrRangesIgnored = errors.New("the server ignored the byte range and sent the whole file (HTTP 200 instead of 206)")
+
+func ChunkCount(contentLength int64, chunkSize int64) int64 {
+	return (contentLength + chunkSize - 1) / chunkSize
+}
+
+func chunkLength(offset int64, chunkSize int64, contentLength int64) int64 {
+	if offset+chunkSize > contentLength {
+		return contentLength - offset
+	}
+	return chunkSize
+}
+
+// autoChunkSize aims for chunksPerWorker chunks per worker so that the work
+// can be spread evenly, while keeping chunks large enough that the round trip
+// of every request is small compared to its transfer (MinChunkSize per 50ms)
+func autoChunkSize(contentLength int64, rtt time.Duration, workers int) int64 {
+	minimum := MinChunkSize * (1 + int64(rtt/(50*time.Millisecond)))
+	if minimum > MaxChunkSize {
+		minimum = MaxChunkSize
+	}
+	chunkSize := contentLength / int64(workers*chunksPerWorker)
+	if chunkSize < minimum {
+		chunkSize = minimum
+	} else if chunkSize > MaxChunkSize {
+		chunkSize = MaxChunkSize
+	}
+	// round up to whole blocks of 64 KiB
+	return (chunkSize + 65535) / 65536 * 65536
+}
+
+func parseContentRange(contentRange string) (int64, int64, int64, error) {
+	invalid := fmt.Errorf("invalid Content-Range header: %q", contentRange)
+	if !strings.HasPrefix(contentRange, "bytes ") {
+		return 0, 0, 0, invalid
+	}
+	byteRange, size, found := strings.Cut(strings.TrimPrefix(contentRange, "bytes "), "/")
+	if !found {
+		return 0, 0, 0, invalid
+	}
+	start, end, found := strings.Cut(byteRange, "-")
+	if !found {
+		return 0, 0, 0, invalid
+	}
+	startOffset, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return 0, 0, 0, invalid
+	}
+	endOffset, err := strconv.ParseInt(end, 10, 64)
+	if err != nil || endOffset < startOffset {
+		return 0, 0, 0, invalid
+	}
+	totalSize := int64(-1)
+	if size != "*" {
+		totalSize, err = strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return 0, 0, 0, invalid
+		}
+	}
+	return startOffset, endOffset, totalSize, nil
+}
+
+func checkRangeResponse(response *http.Response, offset int64, length int64, contentLength int64) error {
+	if response.StatusCode == http.StatusOK {
+		return ErrRangesIgnored
+	}
+	if response.StatusCode != http.StatusPartialContent {
+		return newStatusError(response)
+	}
+	start, end, size, err := parseContentRange(response.Header.Get("Content-Range"))
+	if err != nil {
+		return err
+	}
+	if start != offset || end != offset+length-1 || (size != -1 && size != contentLength) {
+		return fmt.Errorf(
+			"the server sent bytes %v-%v/%v instead of bytes %v-%v/%v",
+			start, end, size, offset, offset+length-1, contentLength,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package paralload
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		contentRange     string
+		start, end, size int64
+		valid            bool
+	}{
+		{"bytes 0-499/1000", 0, 499, 1000, true},
+		{"bytes 500-999/1000", 500, 999, 1000, true},
+		{"bytes 0-0/*", 0, 0, -1, true},
+		{"bytes 10-5/100", 0, 0, 0, false},
+		{"bytes */1000", 0, 0, 0, false},
+		{"bytes 0-499", 0, 0, 0, false},
+		{"items 0-499/1000", 0, 0, 0, false},
+		{"bytes a-b/1000", 0, 0, 0, false},
+		{"bytes 0-499/abc", 0, 0, 0, false},
+		{"", 0, 0, 0, false},
+	}
+	for _, test := range tests {
+		start, end, size, err := parseContentRange(test.contentRange)
+		if (err == nil) != test.valid {
+			t.Errorf("parseContentRange(%q) returned the error %v", test.contentRange, err)
+			continue
+		}
+		if start != test.start || end != test.end || size != test.size {
+			t.Errorf("parseContentRange(%q) = %v, %v, %v, want %v, %v, %v", test.contentRange, start, end, size, test.start, test.end, test.size)
+		}
+	}
+}
+
+func TestCheckRangeResponse(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		contentRange string
+		valid        bool
+	}{
+		{"partial content", http.StatusPartialContent, "bytes 100-199/1000", true},
+		{"unknown size", http.StatusPartialContent, "bytes 100-199/*", true},
+		{"whole file", http.StatusOK, "", false},
+		{"error status", http.StatusNotFound, "", false},
+		{"wrong start", http.StatusPartialContent, "bytes 0-99/1000", false},
+		{"wrong end", http.StatusPartialContent, "bytes 100-299/1000", false},
+		{"wrong size", http.StatusPartialContent, "bytes 100-199/2000", false},
+		{"invalid header", http.StatusPartialContent, "bytes 100-199", false},
+	}
+	for _, test := range tests {
+		response := &http.Response{StatusCode: test.status, Status: http.StatusText(test.status), Header: make(http.Header)}
+		response.Header.Set("Content-Range", test.contentRange)
+		err := checkRangeResponse(response, 100, 100, 1000)
+		if (err == nil) != test.valid {
+			t.Errorf("%v: checkRangeResponse returned the error %v", test.name, err)
+		}
+	}
+	response := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header)}
+	if err := checkRangeResponse(response, 0, 100, 1000); err != ErrRangesIgnored {
+		t.Errorf("checkRangeResponse of a 200 response returned %v, want ErrRangesIgnored", err)
+	}
+}
+
+func TestAutoChunkSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentLength int64
+		rtt           time.Duration
+		workers       int
+		chunkSize     int64
+	}{
+		{"chunks per worker", 1 << 30, 0, 8, 32 << 20},
+		{"rounded up to 64 KiB", 100_000_000, 0, 4, 6291456},
+		{"small file", 1 << 20, 0, 8, MinChunkSize},
+		{"high latency", 1 << 20, 120 * time.Millisecond, 8, 3 * MinChunkSize},
+		{"huge file", 1 << 40, 0, 1, MaxChunkSize},
+		{"very high latency", 1 << 40, time.Minute, 1, MaxChunkSize},
+	}
+	for _, test := range tests {
+		if chunkSize := autoChunkSize(test.contentLength, test.rtt, test.workers); chunkSize != test.chunkSize {
+			t.Errorf("%v: autoChunkSize(%v, %v, %v) = %v, want %v", test.name, test.contentLength, test.rtt, test.workers, chunkSize, test.chunkSize)
+		}
+	}
+}
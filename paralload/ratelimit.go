@@ -0,0 +1,160 @@
+package paralload
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRateLimitWait is the longest a worker sleeps before it checks the limit
+// again, so that changes to it apply quickly
+const maxRateLimitWait = 100 * time.Millisecond
+
+// RateLimiter is a token bucket that limits the combined speed of all workers,
+// it can be shared between downloads and changed while they are running
+type RateLimiter struct {
+	// limit is in bytes per second, 0 means unlimited. It applies whenever
+	// the schedule doesn't contain the current time.
+	limit    int64
+	schedule RateSchedule
+	tokens   float64
+	last     time.Time
+	mutex    sync.Mutex
+}
+
+func NewRateLimiter(limit int64) *RateLimiter {
+	return &RateLimiter{limit: limit, last: time.Now()}
+}
+
+func (rateLimiter *RateLimiter) Limit() int64 {
+	rateLimiter.mutex.Lock()
+	defer rateLimiter.mutex.Unlock()
+	return rateLimiter.limit
+}
+
+// SetLimit changes the limit (in bytes per second, 0 means unlimited)
+func (rateLimiter *RateLimiter) SetLimit(limit int64) {
+	rateLimiter.mutex.Lock()
+	defer rateLimiter.mutex.Unlock()
+	rateLimiter.refill()
+	rateLimiter.limit = limit
+	rateLimiter.refill()
+}
+
+func (rateLimiter *RateLimiter) Schedule() RateSchedule {
+	rateLimiter.mutex.Lock()
+	defer rateLimiter.mutex.Unlock()
+	return rateLimiter.schedule
+}
+
+// SetSchedule makes the limit depend on the time of the day, the limit
+// changes at the boundaries of the windows without interrupting the workers
+func (rateLimiter *RateLimiter) SetSchedule(schedule RateSchedule) {
+	rateLimiter.mutex.Lock()
+	defer rateLimiter.mutex.Unlock()
+	rateLimiter.refill()
+	rateLimiter.schedule = schedule
+	rateLimiter.refill()
+}
+
+// currentLimit returns the limit of the schedule window containing now or
+// the limit set by SetLimit
+func (rateLimiter *RateLimiter) currentLimit(now time.Time) int64 {
+	if rate, ok := rateLimiter.schedule.rate(now); ok {
+		return rate
+	}
+	return rateLimiter.limit
+}
+
+// refill adds the tokens earned since the last refill and returns the
+// current limit, a quarter of a second worth of tokens can be saved up
+func (rateLimiter *RateLimiter) refill() int64 {
+	now := time.Now()
+	limit := rateLimiter.currentLimit(now)
+	rateLimiter.tokens += now.Sub(rateLimiter.last).Seconds() * float64(limit)
+	if burst := float64(limit) / 4; rateLimiter.tokens > burst || limit <= 0 {
+		rateLimiter.tokens = burst
+	}
+	rateLimiter.last = now
+	return limit
+}
+
+// wait blocks until count bytes may be transferred. Writes are allowed as
+// long as there are tokens left, even if they go into debt, which keeps
+// writes that are larger than the bucket from waiting forever.
+func (rateLimiter *RateLimiter) wait(ctx context.Context, count int) error {
+	for {
+		rateLimiter.mutex.Lock()
+		limit := rateLimiter.refill()
+		if limit <= 0 {
+			rateLimiter.mutex.Unlock()
+			return nil
+		}
+		if rateLimiter.tokens > 0 {
+			rateLimiter.tokens -= float64(count)
+			rateLimiter.mutex.Unlock()
+			return nil
+		}
+		delay := time.Duration(-rateLimiter.tokens / float64(limit) * float64(time.Second))
+		rateLimiter.mutex.Unlock()
+
+		if delay > maxRateLimitWait {
+			delay = maxRateLimitWait
+		} else if delay < time.Millisecond {
+			delay = time.Millisecond
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+var rateUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+}
+
+// ParseRate parses a rate in bytes per second such as 500K, 5M or 1.5G
+// (powers of 1024), "unlimited" and 0 disable the limit
+func ParseRate(value string) (int64, error) {
+	rate := strings.ToUpper(strings.TrimSpace(value))
+	if rate == "" || rate == "UNLIMITED" {
+		return 0, nil
+	}
+	rate = strings.TrimSuffix(strings.TrimSuffix(rate, "/S"), "B")
+	unit := int64(1)
+	for _, rateUnit := range rateUnits {
+		if strings.HasSuffix(rate, rateUnit.suffix) {
+			rate = strings.TrimSuffix(rate, rateUnit.suffix)
+			unit = rateUnit.size
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(rate, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("%q is not a valid rate (such as 500K, 5M or unlimited)", value)
+	}
+	return int64(number * float64(unit)), nil
+}
+
+// FormatRate is the opposite of ParseRate
+func FormatRate(rate int64) string {
+	if rate <= 0 {
+		return "unlimited"
+	}
+	for _, rateUnit := range rateUnits {
+		if rate%rateUnit.size == 0 {
+			return strconv.FormatInt(rate/rateUnit.size, 10) + rateUnit.suffix
+		}
+	}
+	return strconv.FormatInt(rate, 10)
+}
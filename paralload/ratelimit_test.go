@@ -0,0 +1,62 @@
+package paralload
+
+import "testing"
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		value string
+		rate  int64
+		valid bool
+	}{
+		{"", 0, true},
+		{"unlimited", 0, true},
+		{"Unlimited", 0, true},
+		{"0", 0, true},
+		{"1000", 1000, true},
+		{"500K", 500 << 10, true},
+		{"500k", 500 << 10, true},
+		{"5M", 5 << 20, true},
+		{"5MB", 5 << 20, true},
+		{"5MB/s", 5 << 20, true},
+		{"1.5G", 3 << 29, true},
+		{" 2M ", 2 << 20, true},
+		{"-1M", 0, false},
+		{"fast", 0, false},
+		{"5T", 0, false},
+	}
+	for _, test := range tests {
+		rate, err := ParseRate(test.value)
+		if (err == nil) != test.valid {
+			t.Errorf("ParseRate(%q) returned the error %v", test.value, err)
+			continue
+		}
+		if rate != test.rate {
+			t.Errorf("ParseRate(%q) = %v, want %v", test.value, rate, test.rate)
+		}
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	tests := []struct {
+		rate  int64
+		value string
+	}{
+		{0, "unlimited"},
+		{-1, "unlimited"},
+		{1000, "1000"},
+		{500 << 10, "500K"},
+		{5 << 20, "5M"},
+		{2 << 30, "2G"},
+		{3 << 29, "1536M"},
+		{1025, "1025"},
+	}
+	for _, test := range tests {
+		value := FormatRate(test.rate)
+		if value != test.value {
+			t.Errorf("FormatRate(%v) = %q, want %q", test.rate, value, test.value)
+		}
+		if rate, err := ParseRate(value); err != nil || rate != test.rate && test.rate > 0 {
+			t.Errorf("ParseRate(FormatRate(%v)) = %v, %v", test.rate, rate, err)
+		}
+	}
+}
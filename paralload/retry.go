@@ -0,0 +1,78 @@
+package paralload
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// the delay between retries doubles with every attempt up to this limit
+const maxRetryDelay = time.Minute
+
+type StatusError struct {
+	StatusCode int
+	Status     string
+	// RetryAfter is the delay requested by a 429 or 503 response
+	RetryAfter time.Duration
+}
+
+func (statusError *StatusError) Error() string {
+	return "unexpected response status: " + statusError.Status
+}
+
+func newStatusError(response *http.Response) *StatusError {
+	statusError := &StatusError{StatusCode: response.StatusCode, Status: response.Status}
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode == http.StatusServiceUnavailable {
+		statusError.RetryAfter = parseRetryAfter(response.Header.Get("Retry-After"))
+	}
+	return statusError
+}
+
+// parseRetryAfter understands both delays in seconds and HTTP dates
+func parseRetryAfter(retryAfter string) time.Duration {
+	if retryAfter == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(retryAfter); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+// isPermanent reports whether retrying err can not succeed, which is the
+// case for client errors apart from 408 Request Timeout and 429 Too Many Requests
+func isPermanent(err error) bool {
+	var statusError *StatusError
+	if !errors.As(err, &statusError) {
+		return false
+	}
+	return statusError.StatusCode >= 400 && statusError.StatusCode < 500 &&
+		statusError.StatusCode != http.StatusRequestTimeout &&
+		statusError.StatusCode != http.StatusTooManyRequests
+}
+
+// retryDelay returns an exponential backoff with jitter for the given
+// attempt (starting at 0), or the delay requested by the server if longer
+func (downloader *Downloader) retryDelay(attempt int, err error) time.Duration {
+	delay := maxRetryDelay
+	if attempt < 16 {
+		delay = downloader.options.RetryDelay << attempt
+		if delay > maxRetryDelay || delay <= 0 {
+			delay = maxRetryDelay
+		}
+	}
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+
+	var statusError *StatusError
+	if errors.As(err, &statusError) && statusError.RetryAfter > delay {
+		delay = statusError.RetryAfter
+	}
+	return delay
+}
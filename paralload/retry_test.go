@@ -0,0 +1,75 @@
+package paralload
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		retryAfter string
+		min, max   time.Duration
+	}{
+		{"", 0, 0},
+		{"120", 2 * time.Minute, 2 * time.Minute},
+		{"0", 0, 0},
+		{"-5", 0, 0},
+		{"soon", 0, 0},
+		{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), 59 * time.Minute, time.Hour},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, 0},
+	}
+	for _, test := range tests {
+		if delay := parseRetryAfter(test.retryAfter); delay < test.min || delay > test.max {
+			t.Errorf("parseRetryAfter(%q) = %v, want between %v and %v", test.retryAfter, delay, test.min, test.max)
+		}
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		err       error
+		permanent bool
+	}{
+		{&StatusError{StatusCode: http.StatusNotFound}, true},
+		{&StatusError{StatusCode: http.StatusForbidden}, true},
+		{&StatusError{StatusCode: http.StatusRequestTimeout}, false},
+		{&StatusError{StatusCode: http.StatusTooManyRequests}, false},
+		{&StatusError{StatusCode: http.StatusInternalServerError}, false},
+		{&StatusError{StatusCode: http.StatusServiceUnavailable}, false},
+		{fmt.Errorf("chunk 1: %w", &StatusError{StatusCode: http.StatusGone}), true},
+		{errors.New("connection reset"), false},
+	}
+	for _, test := range tests {
+		if permanent := isPermanent(test.err); permanent != test.permanent {
+			t.Errorf("isPermanent(%v) = %v, want %v", test.err, permanent, test.permanent)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	downloader := NewDownloader(Options{RetryDelay: time.Second}, nil)
+	tests := []struct {
+		attempt  int
+		err      error
+		min, max time.Duration
+	}{
+		{0, nil, 500 * time.Millisecond, time.Second},
+		{1, nil, time.Second, 2 * time.Second},
+		{3, nil, 4 * time.Second, 8 * time.Second},
+		{10, nil, maxRetryDelay / 2, maxRetryDelay},
+		{100, nil, maxRetryDelay / 2, maxRetryDelay},
+		{0, &StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: 30 * time.Second}, 30 * time.Second, 30 * time.Second},
+		{3, &StatusError{StatusCode: http.StatusServiceUnavailable, RetryAfter: time.Millisecond}, 4 * time.Second, 8 * time.Second},
+	}
+	for _, test := range tests {
+		for i := 0; i < 20; i++ {
+			if delay := downloader.retryDelay(test.attempt, test.err); delay < test.min || delay > test.max {
+				t.Errorf("retryDelay(%v, %v) = %v, want between %v and %v", test.attempt, test.err, delay, test.min, test.max)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package paralload
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateWindow limits the speed between two times of the day
+type RateWindow struct {
+	// Start and End are minutes since midnight, a window that ends before it
+	// starts continues past midnight and one that ends when it starts lasts all day
+	Start int
+	End   int
+	// Rate is in bytes per second, 0 means unlimited
+	Rate int64
+}
+
+// RateSchedule changes the rate limit depending on the time of the day, the
+// first window that contains the current time is used
+type RateSchedule []RateWindow
+
+// ParseRateSchedule parses windows such as "09:00-18:00=2M,18:00-09:00=unlimited"
+func ParseRateSchedule(value string) (RateSchedule, error) {
+	var schedule RateSchedule
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		times, rateText, found := strings.Cut(part, "=")
+		startText, endText, foundEnd := strings.Cut(times, "-")
+		if !found || !foundEnd {
+			return nil, fmt.Errorf("%q must be in the format <start>-<end>=<rate>", part)
+		}
+		start, err := parseTimeOfDay(startText)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimeOfDay(endText)
+		if err != nil {
+			return nil, err
+		}
+		rate, err := ParseRate(rateText)
+		if err != nil {
+			return nil, err
+		}
+		schedule = append(schedule, RateWindow{start, end, rate})
+	}
+	return schedule, nil
+}
+
+func parseTimeOfDay(value string) (int, error) {
+	hoursText, minutesText, found := strings.Cut(strings.TrimSpace(value), ":")
+	hours, hoursErr := strconv.Atoi(hoursText)
+	minutes, minutesErr := strconv.Atoi(minutesText)
+	if !found || hoursErr != nil || minutesErr != nil || hours < 0 || hours > 24 || minutes < 0 || minutes > 59 || (hours == 24 && minutes != 0) {
+		return 0, fmt.Errorf("%q is not a valid time of the day (such as 09:00)", value)
+	}
+	return (hours*60 + minutes) % (24 * 60), nil
+}
+
+func formatTimeOfDay(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+func (window RateWindow) String() string {
+	return formatTimeOfDay(window.Start) + "-" + formatTimeOfDay(window.End) + "=" + FormatRate(window.Rate)
+}
+
+func (schedule RateSchedule) String() string {
+	windows := make([]string, len(schedule))
+	for index, window := range schedule {
+		windows[index] = window.String()
+	}
+	return strings.Join(windows, ",")
+}
+
+func (window RateWindow) contains(minute int) bool {
+	if window.Start < window.End {
+		return minute >= window.Start && minute < window.End
+	} else if window.Start > window.End {
+		return minute >= window.Start || minute < window.End
+	}
+	return true
+}
+
+// rate returns the rate limit at the given time, if a window contains it
+func (schedule RateSchedule) rate(now time.Time) (int64, bool) {
+	minute := now.Hour()*60 + now.Minute()
+	for _, window := range schedule {
+		if window.contains(minute) {
+			return window.Rate, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,89 @@
+package paralload
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRateSchedule(t *testing.T) {
+	tests := []struct {
+		value    string
+		schedule RateSchedule
+		valid    bool
+	}{
+		{"", nil, true},
+		{"09:00-18:00=2M", RateSchedule{{9 * 60, 18 * 60, 2 << 20}}, true},
+		{"09:00-18:00=2M, 18:00-09:00=unlimited", RateSchedule{{9 * 60, 18 * 60, 2 << 20}, {18 * 60, 9 * 60, 0}}, true},
+		{"22:30-24:00=500K", RateSchedule{{22*60 + 30, 0, 500 << 10}}, true},
+		{"09:00-18:00", nil, false},
+		{"09:00=2M", nil, false},
+		{"9-18=2M", nil, false},
+		{"25:00-18:00=2M", nil, false},
+		{"24:30-18:00=2M", nil, false},
+		{"09:60-18:00=2M", nil, false},
+		{"09:00-18:00=fast", nil, false},
+	}
+	for _, test := range tests {
+		schedule, err := ParseRateSchedule(test.value)
+		if (err == nil) != test.valid {
+			t.Errorf("ParseRateSchedule(%q) returned the error %v", test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(schedule, test.schedule) {
+			t.Errorf("ParseRateSchedule(%q) = %v, want %v", test.value, schedule, test.schedule)
+		}
+	}
+}
+
+func TestRateWindowContains(t *testing.T) {
+	tests := []struct {
+		window   RateWindow
+		minute   int
+		contains bool
+	}{
+		{RateWindow{Start: 9 * 60, End: 18 * 60}, 9 * 60, true},
+		{RateWindow{Start: 9 * 60, End: 18 * 60}, 12 * 60, true},
+		{RateWindow{Start: 9 * 60, End: 18 * 60}, 18 * 60, false},
+		{RateWindow{Start: 9 * 60, End: 18 * 60}, 8*60 + 59, false},
+		// windows that cross midnight
+		{RateWindow{Start: 22 * 60, End: 6 * 60}, 23 * 60, true},
+		{RateWindow{Start: 22 * 60, End: 6 * 60}, 0, true},
+		{RateWindow{Start: 22 * 60, End: 6 * 60}, 5*60 + 59, true},
+		{RateWindow{Start: 22 * 60, End: 6 * 60}, 6 * 60, false},
+		{RateWindow{Start: 22 * 60, End: 6 * 60}, 12 * 60, false},
+		{RateWindow{Start: 22 * 60, End: 0}, 23*60 + 59, true},
+		{RateWindow{Start: 22 * 60, End: 0}, 0, false},
+		// a window that ends when it starts lasts all day
+		{RateWindow{Start: 8 * 60, End: 8 * 60}, 3 * 60, true},
+	}
+	for _, test := range tests {
+		if contains := test.window.contains(test.minute); contains != test.contains {
+			t.Errorf("%v contains %v = %v, want %v", test.window, formatTimeOfDay(test.minute), contains, test.contains)
+		}
+	}
+}
+
+func TestRateScheduleRate(t *testing.T) {
+	schedule, err := ParseRateSchedule("22:00-06:00=1M,09:00-18:00=2M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		hour, minute int
+		rate         int64
+		found        bool
+	}{
+		{23, 0, 1 << 20, true},
+		{2, 30, 1 << 20, true},
+		{12, 0, 2 << 20, true},
+		{7, 0, 0, false},
+	}
+	for _, test := range tests {
+		now := time.Date(2024, 1, 1, test.hour, test.minute, 0, 0, time.Local)
+		rate, found := schedule.rate(now)
+		if rate != test.rate || found != test.found {
+			t.Errorf("rate at %02d:%02d = %v, %v, want %v, %v", test.hour, test.minute, rate, found, test.rate, test.found)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package paralload
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// minSplitSize is the smallest range an idle worker takes over from a slow
+// one, smaller ranges are not worth the extra request
+const minSplitSize = 64 * 1024
+
+// maxDuplicates limits the endgame requests racing a single segment
+const maxDuplicates = 2
+
+// errSegmentEnd stops a response early after the rest of its range has
+// been taken over by another worker
+var errSegmentEnd = errors.New("the end of the segment has been reached")
+
+// race is shared by a segment and its endgame duplicates, the first one to
+// finish wins and cancels the others
+type race struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	duplicates int
+	won        bool
+}
+
+// segment is the range of a chunk that is downloaded by a single worker,
+// every chunk starts out as one segment covering all of it
+type segment struct {
+	chunk *Chunk
+	race  *race
+	// position is the amount of bytes (from the start of the segment) that
+	// have been claimed by writes so far, written the amount that is on disk
+	position int64
+	written  int64
+	started  time.Time
+}
+
+// scheduler hands out the chunks to the workers. Once every chunk has been
+// handed out, idle workers split the segment that will take the longest to
+// finish and take over its second half. When nothing is left to split, the
+// endgame starts and idle workers request the rest of the slowest segments
+// again, the overlapping writes are harmless because they contain the same
+// bytes at the same offsets.
+type scheduler struct {
+	info      *Info
+	chunkSize int64
+	state     *State
+	progress  Progress
+	// splittable is false if pieces have to be verified as a whole
+	splittable bool
+	// grace is how long a segment may go without data before it counts as stalled
+	grace time.Duration
+
+	nextChunk int64
+	segments  []*segment
+	// unfinished counts the segments (without duplicates) of every chunk that
+	// are still being downloaded, splits counts how often every chunk has been split
+	unfinished map[int]int
+	splits     map[int]int
+	mutex      sync.Mutex
+}
+
+func newScheduler(info *Info, options Options, state *State, progress Progress) *scheduler {
+	return &scheduler{
+		info:       info,
+		chunkSize:  state.ChunkSize,
+		state:      state,
+		progress:   progress,
+		splittable: options.Pieces == nil,
+		grace:      info.RTT + options.Timeout,
+		unfinished: make(map[int]int),
+		splits:     make(map[int]int),
+	}
+}
+
+// next returns the segment a worker should download next, or nil if there
+// is nothing left that is worth splitting or duplicating
+func (scheduler *scheduler) next(ctx context.Context) *segment {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+
+	chunkCount := ChunkCount(scheduler.info.ContentLength, scheduler.chunkSize)
+	for scheduler.nextChunk < chunkCount {
+		id := scheduler.nextChunk
+		scheduler.nextChunk++
+		if scheduler.state.isComplete(id) {
+			continue
+		}
+		offset := id * scheduler.chunkSize
+		chunk := &Chunk{Id: int(id), Offset: offset, Length: chunkLength(offset, scheduler.chunkSize, scheduler.info.ContentLength)}
+		return scheduler.start(ctx, chunk, nil)
+	}
+
+	if slowest := scheduler.slowest(); slowest != nil && scheduler.splittable {
+		owner := slowest.chunk
+		length := (owner.Length - slowest.position) / 2
+		owner.Length -= length
+		scheduler.splits[owner.Id]++
+		part := &Chunk{Id: owner.Id, Offset: owner.Offset + owner.Length, Length: length, Split: scheduler.splits[owner.Id]}
+		if splitProgress, ok := scheduler.progress.(SplitProgress); ok {
+			splitProgress.ChunkSplit(owner, part)
+		}
+		return scheduler.start(ctx, part, nil)
+	}
+
+	original := scheduler.outstanding()
+	if original == nil {
+		return nil
+	}
+	// pieces are verified as a whole, so the duplicate has to download all of it
+	start := original.written
+	if !scheduler.splittable {
+		start = 0
+	}
+	original.race.duplicates++
+	duplicate := &Chunk{
+		Id:        original.chunk.Id,
+		Offset:    original.chunk.Offset + start,
+		Length:    original.chunk.Length - start,
+		Split:     original.chunk.Split,
+		Duplicate: true,
+	}
+	return scheduler.start(ctx, duplicate, original.race)
+}
+
+// start adds a segment for chunk, duplicates join the race of their original
+func (scheduler *scheduler) start(ctx context.Context, chunk *Chunk, segmentRace *race) *segment {
+	if segmentRace == nil {
+		segmentRace = &race{}
+		segmentRace.ctx, segmentRace.cancel = context.WithCancel(ctx)
+		scheduler.unfinished[chunk.Id]++
+	}
+	segment := &segment{chunk: chunk, race: segmentRace, started: time.Now()}
+	scheduler.segments = append(scheduler.segments, segment)
+	scheduler.progress.ChunkStarted(chunk)
+	return segment
+}
+
+// timeLeft estimates how long the rest of the segment takes to download.
+// Segments that haven't received any data within grace are treated as the
+// slowest, younger ones as the fastest since their speed isn't known yet.
+func (segment *segment) timeLeft(grace time.Duration) float64 {
+	if segment.written == 0 && time.Since(segment.started) < grace {
+		return 0
+	}
+	if segment.written == 0 {
+		return math.Inf(1)
+	}
+	speed := float64(segment.written) / time.Since(segment.started).Seconds()
+	return float64(segment.chunk.Length-segment.written) / speed
+}
+
+// slowest returns the segment with the longest estimated time left that can
+// still be split, segments that are being raced are left alone
+func (scheduler *scheduler) slowest() *segment {
+	var slowest *segment
+	var slowestRemaining int64
+	slowestTimeLeft := -1.0
+	for _, segment := range scheduler.segments {
+		remaining := segment.chunk.Length - segment.position
+		if remaining < 2*minSplitSize || segment.chunk.Duplicate || segment.race.duplicates > 0 {
+			continue
+		}
+		timeLeft := segment.timeLeft(scheduler.grace)
+		if timeLeft > slowestTimeLeft || (timeLeft == slowestTimeLeft && remaining > slowestRemaining) {
+			slowest = segment
+			slowestRemaining = remaining
+			slowestTimeLeft = timeLeft
+		}
+	}
+	return slowest
+}
+
+// outstanding returns the segment that should be duplicated next, which is
+// the slowest one among those with the fewest duplicates
+func (scheduler *scheduler) outstanding() *segment {
+	var outstanding *segment
+	slowestTimeLeft := -1.0
+	for _, segment := range scheduler.segments {
+		if segment.chunk.Duplicate || segment.race.won || segment.race.duplicates >= maxDuplicates ||
+			segment.written >= segment.chunk.Length {
+			continue
+		}
+		timeLeft := segment.timeLeft(scheduler.grace)
+		if outstanding == nil || segment.race.duplicates < outstanding.race.duplicates ||
+			(segment.race.duplicates == outstanding.race.duplicates && timeLeft > slowestTimeLeft) {
+			outstanding = segment
+			slowestTimeLeft = timeLeft
+		}
+	}
+	return outstanding
+}
+
+// remaining returns the position and the length of the segment
+func (scheduler *scheduler) remaining(segment *segment) (int64, int64) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	return segment.position, segment.chunk.Length
+}
+
+// claim reserves up to count bytes at the current position of the segment,
+// so that they can't be split off while they are being written
+func (scheduler *scheduler) claim(segment *segment, count int64) (int64, int64) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	offset := segment.position
+	if remaining := segment.chunk.Length - offset; count > remaining {
+		count = remaining
+	}
+	if count < 0 {
+		count = 0
+	}
+	segment.position += count
+	return offset, count
+}
+
+// release gives back the bytes after offset, they have to be downloaded again
+func (scheduler *scheduler) release(segment *segment, offset int64) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	if segment.position > offset {
+		segment.position = offset
+	}
+	if segment.written > offset {
+		segment.written = offset
+	}
+}
+
+// commit records that the claimed bytes at offset have been written
+func (scheduler *scheduler) commit(segment *segment, offset int64, count int64) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	segment.written = offset + count
+}
+
+// finished removes the segment and reports whether its chunk is complete,
+// which is never the case for segments that lost their race
+func (scheduler *scheduler) finished(segment *segment) bool {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	scheduler.remove(segment)
+	if segment.race.won {
+		return false
+	}
+	segment.race.won = true
+	segment.race.cancel()
+	id := segment.chunk.Id
+	scheduler.unfinished[id]--
+	if scheduler.unfinished[id] > 0 {
+		return false
+	}
+	delete(scheduler.unfinished, id)
+	return true
+}
+
+// abandon removes a duplicate that has failed without ending its race, it
+// still counts towards the duplicates of the race so it isn't retried forever
+func (scheduler *scheduler) abandon(segment *segment) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	scheduler.remove(segment)
+}
+
+func (scheduler *scheduler) remove(segment *segment) {
+	for index, activeSegment := range scheduler.segments {
+		if activeSegment == segment {
+			scheduler.segments = append(scheduler.segments[:index], scheduler.segments[index+1:]...)
+			return
+		}
+	}
+}
+
+type segmentWriter struct {
+	ctx      context.Context
+	download *download
+	segment  *segment
+}
+
+func (segmentWriter *segmentWriter) Write(bytes []byte) (int, error) {
+	if err := segmentWriter.ctx.Err(); err != nil {
+		return 0, err
+	}
+	download := segmentWriter.download
+	segment := segmentWriter.segment
+	if download.options.RateLimiter != nil {
+		if err := download.options.RateLimiter.wait(segmentWriter.ctx, len(bytes)); err != nil {
+			return 0, err
+		}
+	}
+	offset, count := download.scheduler.claim(segment, int64(len(bytes)))
+	if count == 0 {
+		return 0, errSegmentEnd
+	}
+	written, err := download.output.WriteAt(bytes[:count], segment.chunk.Offset+offset)
+	download.scheduler.commit(segment, offset, int64(written))
+	atomic.AddInt64(&download.pool.received, int64(written))
+	if int64(written) < count {
+		download.scheduler.release(segment, offset+int64(written))
+	}
+	download.progress.ChunkProgress(segment.chunk, offset+int64(written))
+	if err == nil && count < int64(len(bytes)) {
+		err = errSegmentEnd
+	}
+	return written, err
+}
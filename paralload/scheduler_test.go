@@ -0,0 +1,112 @@
+package paralload
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSchedulerSplitsSlowSegmentAgain(t *testing.T) {
+	info := &Info{URL: "http://example.com/file", ContentLength: 4 << 20, AcceptsRanges: true, RTT: 50 * time.Millisecond}
+	options := Options{Timeout: 5 * time.Second}
+	state, _ := loadState("", info, 4<<20, false)
+	scheduler := newScheduler(info, options, state, noProgress{})
+
+	// a single slow segment with 3 MiB left at about 100 KiB/s
+	slow := scheduler.next(context.Background())
+	slow.position = 1 << 20
+	slow.written = 1 << 20
+	slow.started = time.Now().Add(-10 * time.Second)
+
+	// the first idle worker takes over the second half of the rest
+	first := scheduler.next(context.Background())
+	if first.chunk.Offset != 2560<<10 || first.chunk.Length != 1536<<10 {
+		t.Fatalf("first split is %v+%v, want %v+%v", first.chunk.Offset, first.chunk.Length, 2560<<10, 1536<<10)
+	}
+
+	// the second one has to split the slow segment again, the new part
+	// hasn't had the time to receive any data yet
+	second := scheduler.next(context.Background())
+	if second.chunk.Offset != 1792<<10 || second.chunk.Length != 768<<10 {
+		t.Fatalf("second split is %v+%v, want %v+%v", second.chunk.Offset, second.chunk.Length, 1792<<10, 768<<10)
+	}
+	if slow.chunk.Length != 1792<<10 {
+		t.Errorf("slow segment has a length of %v, want %v", slow.chunk.Length, 1792<<10)
+	}
+}
+
+func TestSchedulerStalledSegment(t *testing.T) {
+	info := &Info{URL: "http://example.com/file", ContentLength: 4 << 20, AcceptsRanges: true}
+	options := Options{Timeout: time.Second}
+	state, _ := loadState("", info, 2<<20, false)
+	scheduler := newScheduler(info, options, state, noProgress{})
+
+	moving := scheduler.next(context.Background())
+	moving.position = 1 << 20
+	moving.written = 1 << 20
+	moving.started = time.Now().Add(-10 * time.Second)
+
+	// a segment without any data after the grace period is the slowest
+	stalled := scheduler.next(context.Background())
+	stalled.started = time.Now().Add(-2 * time.Second)
+
+	if slowest := scheduler.slowest(); slowest != stalled {
+		t.Errorf("slowest segment starts at %v, want the stalled one at %v", slowest.chunk.Offset, stalled.chunk.Offset)
+	}
+}
+
+func TestSchedulerEndgame(t *testing.T) {
+	info := &Info{URL: "http://example.com/file", ContentLength: 100 << 10, AcceptsRanges: true}
+	state, _ := loadState("", info, 100<<10, false)
+	scheduler := newScheduler(info, Options{Timeout: time.Second}, state, noProgress{})
+
+	// the rest of the only chunk is too small to be split
+	original := scheduler.next(context.Background())
+	original.position = 60 << 10
+	original.written = 60 << 10
+
+	// duplicates continue where the original has written up to
+	duplicates := []*segment{scheduler.next(context.Background()), scheduler.next(context.Background())}
+	for _, duplicate := range duplicates {
+		if duplicate == nil || !duplicate.chunk.Duplicate || duplicate.race != original.race {
+			t.Fatalf("next returned %+v, want a duplicate of the original", duplicate)
+		}
+		if duplicate.chunk.Offset != 60<<10 || duplicate.chunk.Length != 40<<10 {
+			t.Errorf("the duplicate is %v+%v, want %v+%v", duplicate.chunk.Offset, duplicate.chunk.Length, 60<<10, 40<<10)
+		}
+	}
+	if extra := scheduler.next(context.Background()); extra != nil {
+		t.Errorf("next returned a third duplicate %+v", extra.chunk)
+	}
+
+	// the first one to finish completes the chunk and cancels the others
+	if !scheduler.finished(duplicates[1]) {
+		t.Error("the winner didn't complete the chunk")
+	}
+	if original.race.ctx.Err() == nil {
+		t.Error("the losers of the race haven't been cancelled")
+	}
+	if scheduler.finished(original) || scheduler.finished(duplicates[0]) {
+		t.Error("a loser of the race completed the chunk again")
+	}
+	if len(scheduler.segments) != 0 || len(scheduler.unfinished) != 0 {
+		t.Errorf("%v segments and %v unfinished chunks are left", len(scheduler.segments), len(scheduler.unfinished))
+	}
+}
+
+func TestSchedulerEndgamePieces(t *testing.T) {
+	info := &Info{URL: "http://example.com/file", ContentLength: 100 << 10, AcceptsRanges: true}
+	state, _ := loadState("", info, 100<<10, false)
+	options := Options{Timeout: time.Second, Pieces: &Pieces{Algorithm: "sha1", Length: 100 << 10, Hashes: []string{""}}}
+	scheduler := newScheduler(info, options, state, noProgress{})
+
+	original := scheduler.next(context.Background())
+	original.position = 60 << 10
+	original.written = 60 << 10
+
+	// pieces are verified as a whole, so their duplicates start over
+	duplicate := scheduler.next(context.Background())
+	if duplicate == nil || duplicate.chunk.Offset != 0 || duplicate.chunk.Length != 100<<10 {
+		t.Fatalf("next returned %+v, want a duplicate of the whole piece", duplicate)
+	}
+}
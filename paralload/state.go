@@ -0,0 +1,164 @@
+package paralload
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+type State struct {
+	URL           string `json:"url"`
+	ContentLength int64  `json:"contentLength"`
+	ETag          string `json:"etag,omitempty"`
+	LastModified  string `json:"lastModified,omitempty"`
+	ChunkSize     int64  `json:"chunkSize"`
+	Chunks        []byte `json:"chunks"`
+
+	path  string
+	mutex sync.Mutex
+}
+
+// StatePath returns the path of the state file that is kept next to path
+func StatePath(path string) string {
+	return path + ".paralload"
+}
+
+// loadState returns the saved state at path if it belongs to the same file and
+// chunk size (any chunk size if it was picked automatically), otherwise a new
+// empty state is returned. An empty path disables saving.
+func loadState(path string, info *Info, chunkSize int64, automatic bool) (*State, bool) {
+	chunks := ChunkCount(info.ContentLength, chunkSize)
+	state := &State{
+		URL:           info.URL,
+		ContentLength: info.ContentLength,
+		ETag:          info.ETag,
+		LastModified:  info.LastModified,
+		ChunkSize:     chunkSize,
+		Chunks:        make([]byte, (chunks+7)/8),
+		path:          path,
+	}
+	if path == "" {
+		return state, false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return state, false
+	}
+	var savedState State
+	if json.Unmarshal(data, &savedState) != nil {
+		return state, false
+	}
+	if !savedState.matches(info, chunkSize, automatic) {
+		return state, false
+	}
+	state.ChunkSize = savedState.ChunkSize
+	state.Chunks = savedState.Chunks
+	return state, true
+}
+
+// matches reports whether the state belongs to the file described by info
+// and to the chunk size (any chunk size if it is picked automatically)
+func (state *State) matches(info *Info, chunkSize int64, automatic bool) bool {
+	return state.URL == info.URL &&
+		state.ContentLength == info.ContentLength &&
+		state.ETag == info.ETag &&
+		state.LastModified == info.LastModified &&
+		(state.ChunkSize == chunkSize || automatic) &&
+		state.ChunkSize >= 1 &&
+		int64(len(state.Chunks)) == (ChunkCount(info.ContentLength, state.ChunkSize)+7)/8
+}
+
+// continueFile marks the chunks that are covered by the existing data of
+// file as complete, files that are larger than the download are not continued
+func (state *State) continueFile(file *os.File) bool {
+	fileInfo, err := file.Stat()
+	if err != nil || fileInfo.Size() == 0 || fileInfo.Size() > state.ContentLength {
+		return false
+	}
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	for chunk := int64(0); chunk < ChunkCount(state.ContentLength, state.ChunkSize); chunk++ {
+		offset := chunk * state.ChunkSize
+		if offset+chunkLength(offset, state.ChunkSize, state.ContentLength) > fileInfo.Size() {
+			break
+		}
+		state.Chunks[chunk/8] |= 1 << (chunk % 8)
+	}
+	return true
+}
+
+func (state *State) isComplete(chunk int64) bool {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return state.Chunks[chunk/8]&(1<<(chunk%8)) != 0
+}
+
+func (state *State) completedChunks() int64 {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	var count int64
+	for _, chunks := range state.Chunks {
+		for ; chunks != 0; chunks &= chunks - 1 {
+			count++
+		}
+	}
+	return count
+}
+
+// completedChunkList returns the chunks that have been completed
+func (state *State) completedChunkList() []*Chunk {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	var chunks []*Chunk
+	for id := int64(0); id < ChunkCount(state.ContentLength, state.ChunkSize); id++ {
+		if state.Chunks[id/8]&(1<<(id%8)) != 0 {
+			offset := id * state.ChunkSize
+			chunks = append(chunks, &Chunk{Id: int(id), Offset: offset, Length: chunkLength(offset, state.ChunkSize, state.ContentLength)})
+		}
+	}
+	return chunks
+}
+
+func (state *State) markComplete(chunk int64) error {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	state.Chunks[chunk/8] |= 1 << (chunk % 8)
+	return state.save()
+}
+
+func (state *State) save() error {
+	if state.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	// the temporary file is synced before it replaces the state, so that
+	// a crash can't leave an empty state file behind
+	temporaryPath := state.path + ".tmp"
+	file, err := os.OpenFile(temporaryPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporaryPath, state.path)
+}
+
+// removeState deletes the state of a finished download
+func (downloader *Downloader) removeState() {
+	downloader.pausedState = nil
+	if downloader.options.StatePath != "" {
+		os.Remove(downloader.options.StatePath)
+	}
+}
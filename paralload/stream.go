@@ -0,0 +1,45 @@
+package paralload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+func (downloader *Downloader) downloadStream(ctx context.Context, info *Info, output io.WriterAt) error {
+	request, err := downloader.newRequest(ctx, "GET", info.URL)
+	if err != nil {
+		return err
+	}
+	response, err := downloader.newClient(0).Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return newStatusError(response)
+	}
+
+	chunk := &Chunk{Id: 0, Offset: 0, Length: info.ContentLength, Mirror: info.URL}
+	if chunk.Length == -1 {
+		chunk.Length = response.ContentLength
+	}
+	downloader.progress.ChunkStarted(chunk)
+	written, err := io.Copy(&chunkWriter{ctx, output, chunk, 0, downloader.progress, downloader.options.RateLimiter}, response.Body)
+	if err == nil && chunk.Length != -1 && written != chunk.Length {
+		err = io.ErrUnexpectedEOF
+	}
+	// without ranges the download can't continue after the connection has
+	// been paused, so a full disk fails it
+	if isDiskFull(err) {
+		return fmt.Errorf("the disk is full after %v bytes: %w", written, err)
+	}
+	if err != nil {
+		return err
+	}
+	// the length of a stream of unknown size is only known once it has ended
+	chunk.Length = written
+	downloader.progress.ChunkCompleted(chunk)
+	return nil
+}
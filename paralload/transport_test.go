@@ -0,0 +1,108 @@
+package paralload
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const benchmarkSize = 64 << 20
+
+// discardWriter is an output that throws the downloaded bytes away
+type discardWriter struct{}
+
+func (discardWriter) WriteAt(bytes []byte, offset int64) (int, error) {
+	return len(bytes), nil
+}
+
+// transportPerRequest is how requests were made before the transport was
+// shared, every request opens (and closes) its own connection
+type transportPerRequest struct {
+	options   Options
+	tlsConfig *tls.Config
+}
+
+func (roundTripper transportPerRequest) RoundTrip(request *http.Request) (*http.Response, error) {
+	transport := newTransport(roundTripper.options)
+	transport.TLSClientConfig = roundTripper.tlsConfig
+	response, err := transport.RoundTrip(request)
+	if err != nil {
+		transport.CloseIdleConnections()
+		return nil, err
+	}
+	response.Body = &transportBody{response.Body, transport}
+	return response, nil
+}
+
+type transportBody struct {
+	body      io.ReadCloser
+	transport *http.Transport
+}
+
+func (body *transportBody) Read(bytes []byte) (int, error) {
+	return body.body.Read(bytes)
+}
+
+func (body *transportBody) Close() error {
+	err := body.body.Close()
+	body.transport.CloseIdleConnections()
+	return err
+}
+
+// benchmarkTransport downloads from a local TLS server with HTTP/2 enabled
+// and reports the connections opened by every download
+func benchmarkTransport(b *testing.B, http2 bool, perRequest bool) {
+	data := make([]byte, benchmarkSize)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		http.ServeContent(writer, request, "file", time.Time{}, bytes.NewReader(data))
+	}))
+	var connections int64
+	server.Config.ConnState = func(connection net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&connections, 1)
+		}
+	}
+	// connections that are closed during their handshake when a request is cancelled aren't worth logging
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig
+
+	options := Options{Workers: 8, ChunkSize: 1 << 20, HTTP2: http2}
+	b.SetBytes(benchmarkSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		downloader := NewDownloader(options, nil)
+		if perRequest {
+			downloader.transport = transportPerRequest{downloader.options, tlsConfig}
+		} else {
+			downloader.transport.(*http.Transport).TLSClientConfig = tlsConfig
+		}
+		if err := downloader.Download(context.Background(), server.URL, discardWriter{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(atomic.LoadInt64(&connections))/float64(b.N), "connections/op")
+}
+
+func BenchmarkSharedTransport(b *testing.B) {
+	benchmarkTransport(b, false, false)
+}
+
+func BenchmarkSharedTransportHTTP2(b *testing.B) {
+	benchmarkTransport(b, true, false)
+}
+
+func BenchmarkTransportPerRequest(b *testing.B) {
+	benchmarkTransport(b, false, true)
+}
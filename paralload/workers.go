@@ -0,0 +1,186 @@
+package paralload
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// initialAutoWorkers is the amount of workers an adaptive download starts with
+	initialAutoWorkers = 4
+	// throttleRecovery is the amount of intervals without throttling after
+	// which the amount of workers may grow again
+	throttleRecovery = 5
+)
+
+// autoWorkersInterval is how long the throughput is measured before the
+// amount of workers is adjusted again (a variable so that tests can shorten it)
+var autoWorkersInterval = 2 * time.Second
+
+// workerPool limits how many of the worker goroutines download at the same
+// time, lowering the limit lets workers finish their current segment first
+type workerPool struct {
+	// received is the amount of bytes written since the last measurement, it
+	// comes first to stay 64-bit aligned for the atomic operations
+	received  int64
+	throttled int32
+	limit     int
+	active    int
+	closed    bool
+	mutex     sync.Mutex
+	cond      *sync.Cond
+}
+
+func newWorkerPool(limit int) *workerPool {
+	pool := &workerPool{limit: limit}
+	pool.cond = sync.NewCond(&pool.mutex)
+	return pool
+}
+
+// acquire waits until the worker may download and returns false once the
+// pool has been closed
+func (pool *workerPool) acquire() bool {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	for !pool.closed && pool.active >= pool.limit {
+		pool.cond.Wait()
+	}
+	if pool.closed {
+		return false
+	}
+	pool.active++
+	return true
+}
+
+func (pool *workerPool) release() {
+	pool.mutex.Lock()
+	pool.active--
+	pool.mutex.Unlock()
+	pool.cond.Signal()
+}
+
+func (pool *workerPool) setLimit(limit int) {
+	pool.mutex.Lock()
+	pool.limit = limit
+	pool.mutex.Unlock()
+	pool.cond.Broadcast()
+}
+
+func (pool *workerPool) close() {
+	pool.mutex.Lock()
+	pool.closed = true
+	pool.mutex.Unlock()
+	pool.cond.Broadcast()
+}
+
+// WorkerBudget is shared by downloads that run at the same time, so that
+// together they don't use more workers than the budget allows
+type WorkerBudget struct {
+	tokens chan struct{}
+}
+
+func NewWorkerBudget(workers int) *WorkerBudget {
+	return &WorkerBudget{make(chan struct{}, workers)}
+}
+
+// acquire waits until a worker of the budget is free, a nil budget is unlimited
+func (budget *WorkerBudget) acquire(ctx context.Context) bool {
+	if budget == nil {
+		return true
+	}
+	select {
+	case budget.tokens <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (budget *WorkerBudget) release() {
+	if budget != nil {
+		<-budget.tokens
+	}
+}
+
+// isThrottling reports whether err means that the server can't keep up with
+// the amount of connections
+func isThrottling(err error) bool {
+	var statusError *StatusError
+	if errors.As(err, &statusError) {
+		return statusError.StatusCode == http.StatusTooManyRequests || statusError.StatusCode == http.StatusServiceUnavailable
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// tuneWorkers doubles the amount of workers while the throughput keeps
+// rising by more than 10%. Once it stops rising, the download returns to the
+// amount that reached it, and throttling by the server removes a quarter of
+// the workers. Intervals without any data (e.g. while the server is slow to
+// send the first bytes) are not measured, and the workers grow again once
+// the server hasn't throttled for throttleRecovery intervals.
+func (download *download) tuneWorkers(ctx context.Context, pool *workerPool, workers int) {
+	ticker := time.NewTicker(autoWorkersInterval)
+	defer ticker.Stop()
+	var best float64
+	bestWorkers := workers
+	growing := true
+	calmIntervals := 0
+	throttledBefore := false
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		throughput := float64(atomic.SwapInt64(&pool.received, 0)) / autoWorkersInterval.Seconds()
+		previousWorkers := workers
+		if atomic.SwapInt32(&pool.throttled, 0) != 0 {
+			workers = workers * 3 / 4
+			if workers < 1 {
+				workers = 1
+			}
+			growing = false
+			throttledBefore = true
+			calmIntervals = 0
+		} else if throughput == 0 {
+			continue
+		} else if throttledBefore && !growing {
+			calmIntervals++
+			if calmIntervals >= throttleRecovery {
+				// start measuring again from the reduced amount of workers
+				growing = true
+				throttledBefore = false
+				best = throughput
+				bestWorkers = workers
+				workers = download.grow(workers)
+			}
+		} else if growing && throughput > best*1.1 {
+			best = throughput
+			bestWorkers = workers
+			workers = download.grow(workers)
+		} else if growing {
+			workers = bestWorkers
+			growing = false
+		}
+		if workers != previousWorkers {
+			pool.setLimit(workers)
+			if workersProgress, ok := download.progress.(WorkersProgress); ok {
+				workersProgress.WorkersChanged(workers)
+			}
+		}
+	}
+}
+
+// grow doubles the workers up to Options.Workers
+func (download *download) grow(workers int) int {
+	workers *= 2
+	if workers > download.options.Workers {
+		workers = download.options.Workers
+	}
+	return workers
+}
@@ -0,0 +1,76 @@
+package paralload
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type workerChanges struct {
+	noProgress
+	changes []int
+	mutex   sync.Mutex
+}
+
+func (progress *workerChanges) WorkersChanged(workers int) {
+	progress.mutex.Lock()
+	progress.changes = append(progress.changes, workers)
+	progress.mutex.Unlock()
+}
+
+// slowResponseWriter limits the speed of every connection, so that more
+// workers give a higher throughput
+type slowResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (writer slowResponseWriter) Write(bytes []byte) (int, error) {
+	written := 0
+	for written < len(bytes) {
+		end := written + 16*1024
+		if end > len(bytes) {
+			end = len(bytes)
+		}
+		count, err := writer.ResponseWriter.Write(bytes[written:end])
+		written += count
+		if err != nil {
+			return written, err
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return written, nil
+}
+
+func TestAutoWorkersSlowFirstByte(t *testing.T) {
+	defer func(interval time.Duration) { autoWorkersInterval = interval }(autoWorkersInterval)
+	autoWorkersInterval = 100 * time.Millisecond
+	data := make([]byte, 8<<20)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// no data arrives during the first intervals
+		if request.Header.Get("Range") != "bytes=0-0" && request.Method == "GET" {
+			time.Sleep(250 * time.Millisecond)
+		}
+		http.ServeContent(slowResponseWriter{writer}, request, "file", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	progress := &workerChanges{}
+	options := Options{AutoWorkers: true, Workers: 32, ChunkSize: 256 * 1024}
+	err := NewDownloader(options, progress).Download(context.Background(), server.URL, discardWriter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxWorkers := 0
+	for _, workers := range progress.changes {
+		if workers > maxWorkers {
+			maxWorkers = workers
+		}
+	}
+	if maxWorkers <= initialAutoWorkers {
+		t.Fatalf("the workers never grew beyond %v: %v", initialAutoWorkers, progress.changes)
+	}
+}
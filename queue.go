@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"ryan/paralload/paralload"
+)
+
+type queueStatus int
+
+const (
+	statusQueued queueStatus = iota
+	statusDownloading
+	statusPaused
+	statusCancelled
+	statusCompleted
+	statusFailed
+)
+
+var (
+	queueItems             []*QueueItem
+	queueMutex             sync.Mutex
+	queueContainer         *fyne.Container
+	maxConcurrentDownloads int = 3
+)
+
+// QueueItem is a download in the queue of the GUI, its fields are guarded by queueMutex
+type QueueItem struct {
+	url     string
+	path    string
+	options paralload.Options
+	status  queueStatus
+	// downloader keeps the completed chunks of the download while it is paused
+	downloader *paralload.Downloader
+	// running stays true until the download has stopped after being paused or
+	// cancelled, so that it isn't started twice at the same time
+	running bool
+	cancel  context.CancelFunc
+	err     error
+	counter *byteCounter
+	workers int
+	// retryError is the latest failure of a chunk while chunks are being retried
+	retryError string
+
+	statusLabel  *widget.Label
+	statsLabel   *widget.Label
+	progressBar  *widget.ProgressBar
+	pauseButton  *widget.Button
+	cancelButton *widget.Button
+	chunkMap     *ChunkMap
+	container    *fyne.Container
+}
+
+func newQueueItem(url string, path string, options paralload.Options) *QueueItem {
+	item := &QueueItem{url: url, path: path, options: options, counter: newByteCounter()}
+	item.downloader = newGuiDownloader(item)
+	item.chunkMap = NewChunkMap(item.downloader.Options().MaxRetries)
+	item.statusLabel = widget.NewLabel("")
+	item.statsLabel = widget.NewLabel("")
+	item.progressBar = widget.NewProgressBar()
+	item.pauseButton = widget.NewButtonWithIcon("", theme.MediaPauseIcon(), item.togglePause)
+	item.cancelButton = widget.NewButtonWithIcon("", theme.CancelIcon(), item.cancelDownload)
+	removeButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), item.remove)
+	// the map of the chunks is only shown on request
+	item.chunkMap.Hide()
+	chunksCheck := widget.NewCheck("Chunks", func(checked bool) {
+		if checked {
+			item.chunkMap.Show()
+		} else {
+			item.chunkMap.Hide()
+		}
+	})
+	buttonContainer := fyne.NewContainerWithLayout(layout.NewHBoxLayout(), chunksCheck, item.pauseButton, item.cancelButton, removeButton)
+	nameLabel := widget.NewLabelWithStyle(filepath.Base(path), fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+	item.container = fyne.NewContainerWithLayout(
+		layout.NewVBoxLayout(),
+		fyne.NewContainerWithLayout(layout.NewBorderLayout(nil, nil, nil, buttonContainer), nameLabel, buttonContainer),
+		item.progressBar,
+		item.statsLabel,
+		item.statusLabel,
+		item.chunkMap,
+		widget.NewSeparator(),
+	)
+	item.updateControls()
+	return item
+}
+
+// isQueued reports whether a download that hasn't finished yet is saved to path
+func isQueued(path string) bool {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	for _, item := range queueItems {
+		if item.path == path && item.status != statusCompleted && item.status != statusCancelled {
+			return true
+		}
+	}
+	return false
+}
+
+func addToQueue(item *QueueItem) {
+	queueMutex.Lock()
+	if len(queueItems) == 0 {
+		queueContainer.RemoveAll()
+	}
+	queueItems = append(queueItems, item)
+	queueContainer.Add(item.container)
+	saveQueue()
+	queueMutex.Unlock()
+	startQueuedDownloads()
+}
+
+// startQueuedDownloads starts queued downloads in the order they were added
+// until maxConcurrentDownloads are running
+func startQueuedDownloads() {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	running := 0
+	for _, item := range queueItems {
+		if item.running {
+			running++
+		}
+	}
+	for _, item := range queueItems {
+		if running >= maxConcurrentDownloads {
+			break
+		}
+		if item.status != statusQueued || item.running {
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		item.status = statusDownloading
+		item.running = true
+		item.cancel = cancel
+		item.err = nil
+		item.updateControls()
+		running++
+		go item.run(ctx)
+	}
+}
+
+func (item *QueueItem) run(ctx context.Context) {
+	err := item.downloader.DownloadFile(ctx, item.url, item.path)
+	queueMutex.Lock()
+	item.cancel()
+	item.running = false
+	// paused and cancelled downloads already have their new status, unless
+	// the last chunks completed the file while they were stopping
+	if err == nil {
+		item.status = statusCompleted
+	} else if item.status == statusDownloading {
+		item.status = statusFailed
+		item.err = err
+	}
+	item.updateControls()
+	saveQueue()
+	status := item.status
+	queueMutex.Unlock()
+
+	if status == statusFailed {
+		showDownloadError(item.path, err)
+	}
+	startQueuedDownloads()
+}
+
+// togglePause pauses a queued or running download, or queues a paused,
+// failed or cancelled download again. A running download stops once its
+// current chunks are complete, so that none of the downloaded data is lost.
+func (item *QueueItem) togglePause() {
+	queueMutex.Lock()
+	switch item.status {
+	case statusQueued:
+		item.status = statusPaused
+		if item.running {
+			item.downloader.Pause()
+		}
+	case statusDownloading:
+		item.status = statusPaused
+		item.downloader.Pause()
+	case statusPaused, statusFailed, statusCancelled:
+		item.status = statusQueued
+	}
+	item.updateControls()
+	saveQueue()
+	queueMutex.Unlock()
+	startQueuedDownloads()
+}
+
+// cancelDownload stops the download right away, even if it is pausing
+func (item *QueueItem) cancelDownload() {
+	queueMutex.Lock()
+	switch item.status {
+	case statusDownloading, statusQueued, statusPaused:
+		if item.running {
+			item.cancel()
+		}
+		item.status = statusCancelled
+	}
+	item.updateControls()
+	saveQueue()
+	queueMutex.Unlock()
+}
+
+// remove takes the download out of the queue, a running download is cancelled first
+func (item *QueueItem) remove() {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	if item.running {
+		item.cancel()
+		if item.status != statusCompleted {
+			item.status = statusCancelled
+		}
+	}
+	for index, queueItem := range queueItems {
+		if queueItem == item {
+			queueItems = append(queueItems[:index], queueItems[index+1:]...)
+			break
+		}
+	}
+	queueContainer.Remove(item.container)
+	if len(queueItems) == 0 {
+		showEmptyQueue()
+	}
+	saveQueue()
+}
+
+func showEmptyQueue() {
+	queueContainer.RemoveAll()
+	queueContainer.Add(layout.NewSpacer())
+	queueContainer.Add(fyne.NewContainerWithLayout(layout.NewCenterLayout(), widget.NewLabel("There are no downloads in the queue...")))
+	queueContainer.Add(layout.NewSpacer())
+}
+
+// updateControls shows the status of the download, queueMutex has to be held
+func (item *QueueItem) updateControls() {
+	pauseText, pauseIcon := "", theme.MediaPauseIcon()
+	item.cancelButton.Enable()
+	switch item.status {
+	case statusQueued:
+		item.statusLabel.SetText("Queued")
+	case statusDownloading:
+		if item.retryError != "" {
+			item.statusLabel.SetText("Downloading, " + item.retryError)
+		} else {
+			item.statusLabel.SetText("Downloading...")
+		}
+	case statusPaused:
+		if item.running {
+			item.statusLabel.SetText("Pausing once the current chunks are complete...")
+		} else {
+			item.statusLabel.SetText("Paused")
+		}
+		pauseIcon = theme.MediaPlayIcon()
+	case statusCancelled:
+		item.statusLabel.SetText("Cancelled, the data has been kept in " + filepath.Base(paralload.PartPath(item.path)))
+		pauseIcon = theme.ViewRefreshIcon()
+		item.cancelButton.Disable()
+	case statusCompleted:
+		message := "Completed"
+		if item.options.Checksum != nil {
+			message += fmt.Sprintf(", the %v checksum has been verified", item.options.Checksum.Algorithm)
+		}
+		if item.options.AutoWorkers {
+			message += fmt.Sprintf(", settled on %v workers", item.workers)
+		}
+		item.statusLabel.SetText(message)
+		item.progressBar.SetValue(item.progressBar.Max)
+		item.cancelButton.Disable()
+	case statusFailed:
+		item.statusLabel.SetText("Failed: " + item.err.Error())
+		pauseIcon = theme.ViewRefreshIcon()
+	}
+	if item.status == statusCompleted {
+		item.pauseButton.Disable()
+	} else {
+		item.pauseButton.Enable()
+	}
+	item.pauseButton.SetText(pauseText)
+	item.pauseButton.SetIcon(pauseIcon)
+	if !item.running {
+		item.statsLabel.SetText("")
+	}
+}
+
+// refreshQueue updates the progress, the speed and the time left of the
+// running (or pausing) downloads
+func refreshQueue() {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for range ticker.C {
+		queueMutex.Lock()
+		for _, item := range queueItems {
+			if !item.running {
+				continue
+			}
+			stats := item.counter.stats()
+			if stats.total > 0 {
+				item.progressBar.Max = float64(stats.total)
+				item.progressBar.SetValue(float64(stats.current))
+			}
+			item.statsLabel.SetText(stats.String())
+			if item.chunkMap.Visible() {
+				item.chunkMap.Refresh()
+			}
+		}
+		queueMutex.Unlock()
+	}
+}
+
+func showDownloadError(path string, err error) {
+	name := filepath.Base(path)
+	var checksumError *paralload.ChecksumError
+	var diskSpaceError *paralload.DiskSpaceError
+	if err == paralload.ErrRangesIgnored {
+		dialog.ShowInformation("Unsupported", name+":\n"+wrapText(err.Error()), mainWindow)
+	} else if errors.As(err, &diskSpaceError) {
+		dialog.ShowInformation(
+			"Not Enough Space",
+			fmt.Sprintf("%v needs %v more bytes than there are available.\nPlease free up some space or choose another location.", name, diskSpaceError.Needed-diskSpaceError.Available),
+			mainWindow,
+		)
+	} else if errors.As(err, &checksumError) {
+		dialog.ShowInformation(
+			"Checksum Mismatch",
+			fmt.Sprintf("The %v checksum of %v does not match!\n\nExpected: %v\nActual: %v", checksumError.Algorithm, name, checksumError.Expected, checksumError.Actual),
+			mainWindow,
+		)
+	} else {
+		dialog.ShowInformation("Error", name+":\n"+wrapText(err.Error()), mainWindow)
+	}
+}
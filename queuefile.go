@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ryan/paralload/paralload"
+)
+
+// savedQueueItem is a download that hasn't completed yet, it is kept in the
+// queue file so that it can be resumed after Paralload has been restarted
+type savedQueueItem struct {
+	URL         string              `json:"url"`
+	Path        string              `json:"path"`
+	Cancelled   bool                `json:"cancelled,omitempty"`
+	Workers     int                 `json:"workers"`
+	AutoWorkers bool                `json:"autoWorkers,omitempty"`
+	ChunkSize   int64               `json:"chunkSize,omitempty"`
+	Timeout     time.Duration       `json:"timeout"`
+	UserAgent   string              `json:"userAgent"`
+	MaxRetries  int                 `json:"maxRetries"`
+	Mirrors     []string            `json:"mirrors,omitempty"`
+	Checksum    *paralload.Checksum `json:"checksum,omitempty"`
+	Continue    bool                `json:"continue,omitempty"`
+	Preallocate bool                `json:"preallocate,omitempty"`
+}
+
+// queueFilePath returns where the queue is kept, or an empty string if
+// there is no configuration directory
+func queueFilePath() string {
+	directory, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(directory, "paralload", "queue.json")
+}
+
+// saveQueue writes the unfinished downloads to the queue file, queueMutex has
+// to be held. Failures are ignored since the downloads themselves are not
+// affected by them.
+func saveQueue() {
+	path := queueFilePath()
+	if path == "" {
+		return
+	}
+	savedItems := []savedQueueItem{}
+	for _, item := range queueItems {
+		if item.status == statusCompleted {
+			continue
+		}
+		savedItems = append(savedItems, savedQueueItem{
+			URL:         item.url,
+			Path:        item.path,
+			Cancelled:   item.status == statusCancelled,
+			Workers:     item.options.Workers,
+			AutoWorkers: item.options.AutoWorkers,
+			ChunkSize:   item.options.ChunkSize,
+			Timeout:     item.options.Timeout,
+			UserAgent:   item.options.UserAgent,
+			MaxRetries:  item.options.MaxRetries,
+			Mirrors:     item.options.Mirrors,
+			Checksum:    item.options.Checksum,
+			Continue:    item.options.Continue,
+			Preallocate: item.options.Preallocate,
+		})
+	}
+	data, err := json.MarshalIndent(savedItems, "", "\t")
+	if err != nil {
+		return
+	}
+	if os.MkdirAll(filepath.Dir(path), 0755) != nil {
+		return
+	}
+	temporaryPath := path + ".tmp"
+	if os.WriteFile(temporaryPath, data, 0644) != nil {
+		return
+	}
+	os.Rename(temporaryPath, path)
+}
+
+// loadQueue adds the downloads of the queue file to the queue, they are
+// paused until they are resumed and continue from their part and state files
+func loadQueue() {
+	path := queueFilePath()
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var savedItems []savedQueueItem
+	if json.Unmarshal(data, &savedItems) != nil {
+		return
+	}
+	for _, savedItem := range savedItems {
+		options := paralload.Options{
+			Workers:     savedItem.Workers,
+			AutoWorkers: savedItem.AutoWorkers,
+			ChunkSize:   savedItem.ChunkSize,
+			Timeout:     savedItem.Timeout,
+			UserAgent:   savedItem.UserAgent,
+			MaxRetries:  savedItem.MaxRetries,
+			StatePath:   paralload.StatePath(savedItem.Path),
+			Mirrors:     savedItem.Mirrors,
+			Checksum:    savedItem.Checksum,
+			Continue:    savedItem.Continue,
+			Preallocate: savedItem.Preallocate,
+			RateLimiter: rateLimiter,
+		}
+		item := newQueueItem(savedItem.URL, savedItem.Path, options)
+		item.status = statusPaused
+		if savedItem.Cancelled {
+			item.status = statusCancelled
+		}
+		item.updateControls()
+		queueMutex.Lock()
+		if len(queueItems) == 0 {
+			queueContainer.RemoveAll()
+		}
+		queueItems = append(queueItems, item)
+		queueContainer.Add(item.container)
+		queueMutex.Unlock()
+	}
+}